@@ -0,0 +1,172 @@
+package carddata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/pagarme/cryptokit"
+)
+
+var ErrUnknownFormat = errors.New("Unknown card data format")
+
+const (
+	TagTrack1Data            = 0x56
+	TagTrack2EquivalentData  = 0x57
+	TagPan                   = 0x5A
+	TagCardholderName        = 0x5F20
+	TagApplicationExpiryDate = 0x5F24
+	TagServiceCode           = 0x5F30
+)
+
+// Card holds the card data found in a payload, Track1, Track2 and Tlv being
+// set according to what it holds. Expiry is given as YYMM.
+type Card struct {
+	Pan         string
+	Name        string
+	Expiry      string
+	ServiceCode string
+	Track1      *Track1
+	Track2      *Track2
+	Tlv         TlvList
+}
+
+// Parse parses a decrypted card data payload, which may be track 1 or
+// track 2 data or EMV BER-TLV data, the fields of the card being taken from
+// the tracks or from the EMV tags
+func Parse(data []byte) (*Card, error) {
+	trimmed := bytes.TrimRight(data, "\x00")
+
+	if len(trimmed) == 0 {
+		return nil, ErrUnknownFormat
+	}
+
+	switch {
+	case trimmed[0] == '%' || trimmed[0] == 'B' && hasPanBefore(trimmed[1:], '^'):
+		track1, err := ParseTrack1(trimmed)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Card{
+			Pan:         track1.Pan,
+			Name:        track1.Name,
+			Expiry:      track1.Expiry,
+			ServiceCode: track1.ServiceCode,
+			Track1:      track1,
+		}, nil
+	case trimmed[0] == ';' || hasPanBefore(trimmed, '='):
+		track2, err := ParseTrack2(trimmed)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Card{
+			Pan:         track2.Pan,
+			Expiry:      track2.Expiry,
+			ServiceCode: track2.ServiceCode,
+			Track2:      track2,
+		}, nil
+	}
+
+	tlv, err := ParseTlv(data)
+
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	return fromTlv(tlv)
+}
+
+// Decrypt decrypts a card data payload, typically with a DUKPT data
+// mechanism, and parses it
+func Decrypt(session cryptokit.Session, mech cryptokit.Mechanism, key cryptokit.Key, in []byte) (*Card, error) {
+	data, err := session.Decrypt(mech, key, in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// MaskedPan returns the PAN of the card masked with MaskPan
+func (c *Card) MaskedPan() string {
+	return MaskPan(c.Pan)
+}
+
+// MaskPan replaces the digits of a PAN but its first six and last four
+// with asterisks. Only the last four digits are kept for PANs shorter than
+// 13 digits, as keeping the BIN would disclose most of them.
+func MaskPan(pan string) string {
+	if len(pan) <= 4 {
+		return strings.Repeat("*", len(pan))
+	}
+
+	first := 6
+
+	if len(pan) < 13 {
+		first = 0
+	}
+
+	return pan[:first] + strings.Repeat("*", len(pan)-first-4) + pan[len(pan)-4:]
+}
+
+// hasPanBefore reports whether data starts with a PAN followed by the
+// separator, telling unsentineled tracks from TLV data
+func hasPanBefore(data []byte, separator byte) bool {
+	i := bytes.IndexByte(data, separator)
+
+	return i >= 0 && isPan(string(data[:i]))
+}
+
+func fromTlv(tlv TlvList) (*Card, error) {
+	card := &Card{Tlv: tlv}
+
+	if t, ok := tlv.Find(TagTrack2EquivalentData); ok {
+		track2, err := ParseTrack2EquivalentData(t.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		card.Track2 = track2
+		card.Pan, card.Expiry, card.ServiceCode = track2.Pan, track2.Expiry, track2.ServiceCode
+	}
+
+	if t, ok := tlv.Find(TagTrack1Data); ok {
+		track1, err := ParseTrack1(t.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		card.Track1 = track1
+		card.Name = track1.Name
+	}
+
+	if t, ok := tlv.Find(TagPan); ok {
+		card.Pan = strings.TrimRight(strings.ToUpper(hex.EncodeToString(t.Value)), "F")
+	}
+
+	if t, ok := tlv.Find(TagCardholderName); ok {
+		card.Name = strings.TrimRight(string(t.Value), " ")
+	}
+
+	if t, ok := tlv.Find(TagApplicationExpiryDate); ok && len(t.Value) == 3 {
+		card.Expiry = hex.EncodeToString(t.Value[:2])
+	}
+
+	if t, ok := tlv.Find(TagServiceCode); ok && len(t.Value) == 2 {
+		card.ServiceCode = hex.EncodeToString(t.Value)[1:]
+	}
+
+	if card.Pan == "" {
+		return nil, ErrUnknownFormat
+	}
+
+	return card, nil
+}
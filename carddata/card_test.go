@@ -0,0 +1,88 @@
+package carddata
+
+import (
+	"encoding/hex"
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+var testMasterKey = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+var testBdk = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10}
+var testKsn = []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x08}
+var testTrack1 = []byte("%B5452300551227189^HOGAN/PAUL      ^08043210000000725000000?\x00\x00\x00\x00")
+
+func TestParse(t *testing.T) {
+	card, err := Parse([]byte("5413330089010434=25122010000000000000\x00\x00\x00"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "5413330089010434", card.Pan)
+	assert.Equal(t, "201", card.ServiceCode)
+	assert.NotNil(t, card.Track2)
+	assert.Nil(t, card.Track1)
+
+	data, _ := hex.DecodeString("703757135413330089010434d25122010000000000000f5a" +
+		"0854133300890104345f200a484f47414e2f5041554c5f24032512315f30020201" +
+		"0000")
+
+	card, err = Parse(data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "5413330089010434", card.Pan)
+	assert.Equal(t, "HOGAN/PAUL", card.Name)
+	assert.Equal(t, "2512", card.Expiry)
+	assert.Equal(t, "201", card.ServiceCode)
+	assert.Equal(t, "0000000000000", card.Track2.DiscretionaryData)
+	assert.Len(t, card.Tlv, 1)
+
+	_, err = Parse([]byte{0x00, 0x00})
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestDecrypt(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := soft.New("testdb.db", testMasterKey)
+	assert.Nil(t, err)
+
+	s, err := p.OpenSession()
+	assert.Nil(t, err)
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err)
+
+	mech := cryptokit.DukptData{Ksn: testKsn}
+
+	ciphertext, err := s.Encrypt(mech, bdk, testTrack1)
+
+	assert.Nil(t, err)
+
+	card, err := Decrypt(s, mech, bdk, ciphertext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "5452300551227189", card.Pan)
+	assert.Equal(t, "HOGAN/PAUL", card.Name)
+	assert.Equal(t, "0804", card.Expiry)
+	assert.Equal(t, "321", card.ServiceCode)
+	assert.Equal(t, "0000000725000000", card.Track1.DiscretionaryData)
+	assert.Equal(t, "545230******7189", card.MaskedPan())
+}
+
+func TestMaskPan(t *testing.T) {
+	assert.Equal(t, "411111******1111", MaskPan("4111111111111111"))
+	assert.Equal(t, "541333*********0434", MaskPan("5413330089010430434"))
+	assert.Equal(t, "********1234", MaskPan("411111111234"))
+	assert.Equal(t, "****", MaskPan("1234"))
+}
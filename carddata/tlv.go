@@ -0,0 +1,139 @@
+package carddata
+
+import (
+	"errors"
+)
+
+var ErrInvalidTlv = errors.New("Invalid BER-TLV data")
+
+// Tlv is a BER-TLV data object. The tag is kept as it is encoded, 0x9F02
+// for instance, and the children of constructed objects are parsed.
+type Tlv struct {
+	Tag      uint32
+	Value    []byte
+	Children TlvList
+}
+
+type TlvList []Tlv
+
+// Constructed reports whether the object holds other objects
+func (t Tlv) Constructed() bool {
+	first := t.Tag
+
+	for first > 0xFF {
+		first >>= 8
+	}
+
+	return first&0x20 != 0
+}
+
+// ParseTlv parses a sequence of BER-TLV data objects as used by EMV. The
+// 0x00 and 0xFF bytes EMV allows between objects are skipped, so the zero
+// padding of a decrypted payload is ignored.
+func ParseTlv(data []byte) (TlvList, error) {
+	var result TlvList
+
+	for len(data) > 0 {
+		if data[0] == 0x00 || data[0] == 0xFF {
+			data = data[1:]
+			continue
+		}
+
+		tag, n, err := parseTag(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data = data[n:]
+
+		length, n, err := parseLength(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data = data[n:]
+
+		if length > len(data) {
+			return nil, ErrInvalidTlv
+		}
+
+		t := Tlv{Tag: tag, Value: data[:length]}
+		data = data[length:]
+
+		if t.Constructed() {
+			if t.Children, err = ParseTlv(t.Value); err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, t)
+	}
+
+	return result, nil
+}
+
+// Find returns the first object with the tag, searching constructed
+// objects depth first
+func (l TlvList) Find(tag uint32) (Tlv, bool) {
+	for _, t := range l {
+		if t.Tag == tag {
+			return t, true
+		}
+
+		if found, ok := t.Children.Find(tag); ok {
+			return found, true
+		}
+	}
+
+	return Tlv{}, false
+}
+
+func parseTag(data []byte) (uint32, int, error) {
+	tag := uint32(data[0])
+	n := 1
+
+	// Subsequent tag bytes follow when the number bits are all set, each
+	// one but the last having its high bit set
+	if data[0]&0x1F == 0x1F {
+		for {
+			if n >= len(data) || n > 3 {
+				return 0, 0, ErrInvalidTlv
+			}
+
+			tag = tag<<8 | uint32(data[n])
+			n++
+
+			if data[n-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	return tag, n, nil
+}
+
+func parseLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, ErrInvalidTlv
+	}
+
+	if data[0]&0x80 == 0 {
+		return int(data[0]), 1, nil
+	}
+
+	count := int(data[0] & 0x7F)
+
+	if count == 0 || count > 3 || len(data) < 1+count {
+		return 0, 0, ErrInvalidTlv
+	}
+
+	length := 0
+
+	for _, b := range data[1 : 1+count] {
+		length = length<<8 | int(b)
+	}
+
+	return length, 1 + count, nil
+}
@@ -0,0 +1,43 @@
+package carddata
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTlv(t *testing.T) {
+	data, _ := hex.DecodeString("70105a0854133300890104345f24032512319f02060000000010009f10810301020300000000")
+
+	tlv, err := ParseTlv(data)
+
+	assert.Nil(t, err)
+	assert.Len(t, tlv, 3)
+	assert.True(t, tlv[0].Constructed())
+	assert.Len(t, tlv[0].Children, 2)
+
+	pan, ok := tlv.Find(TagPan)
+
+	assert.True(t, ok)
+	assert.Equal(t, "5413330089010434", hex.EncodeToString(pan.Value))
+
+	amount, ok := tlv.Find(0x9F02)
+
+	assert.True(t, ok)
+	assert.False(t, amount.Constructed())
+	assert.Equal(t, "000000001000", hex.EncodeToString(amount.Value))
+
+	iad, ok := tlv.Find(0x9F10)
+
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3}, iad.Value)
+
+	_, ok = tlv.Find(0x5F20)
+	assert.False(t, ok)
+
+	_, err = ParseTlv(data[:10])
+	assert.Equal(t, ErrInvalidTlv, err)
+
+	_, err = ParseTlv([]byte{0x9F})
+	assert.Equal(t, ErrInvalidTlv, err)
+}
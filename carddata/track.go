@@ -0,0 +1,149 @@
+package carddata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidTrack1 = errors.New("Invalid track 1 data")
+	ErrInvalidTrack2 = errors.New("Invalid track 2 data")
+)
+
+// Track1 holds the fields of ISO/IEC 7813 track 1 data. Expiry is given as
+// YYMM.
+type Track1 struct {
+	FormatCode        byte
+	Pan               string
+	Name              string
+	Expiry            string
+	ServiceCode       string
+	DiscretionaryData string
+}
+
+// Track2 holds the fields of ISO/IEC 7813 track 2 data. Expiry is given as
+// YYMM.
+type Track2 struct {
+	Pan               string
+	Expiry            string
+	ServiceCode       string
+	DiscretionaryData string
+}
+
+// ParseTrack1 parses track 1 data, with or without its sentinels. Data
+// after the end sentinel, such as the LRC or the padding of a decrypted
+// payload, is ignored.
+func ParseTrack1(data []byte) (*Track1, error) {
+	s, ok := trimSentinels(data, '%')
+
+	if !ok || len(s) < 1 || s[0] < 'A' || s[0] > 'Z' {
+		return nil, ErrInvalidTrack1
+	}
+
+	fields := strings.SplitN(s[1:], "^", 3)
+
+	if len(fields) != 3 || !isPan(fields[0]) || len(fields[1]) < 2 || len(fields[1]) > 26 {
+		return nil, ErrInvalidTrack1
+	}
+
+	expiry, serviceCode, discretionary, ok := splitTrailer(fields[2])
+
+	if !ok {
+		return nil, ErrInvalidTrack1
+	}
+
+	return &Track1{
+		FormatCode:        s[0],
+		Pan:               fields[0],
+		Name:              strings.TrimRight(fields[1], " "),
+		Expiry:            expiry,
+		ServiceCode:       serviceCode,
+		DiscretionaryData: discretionary,
+	}, nil
+}
+
+// ParseTrack2 parses track 2 data, with or without its sentinels. Data
+// after the end sentinel is ignored.
+func ParseTrack2(data []byte) (*Track2, error) {
+	s, ok := trimSentinels(data, ';')
+
+	if !ok {
+		return nil, ErrInvalidTrack2
+	}
+
+	fields := strings.SplitN(s, "=", 2)
+
+	if len(fields) != 2 || !isPan(fields[0]) {
+		return nil, ErrInvalidTrack2
+	}
+
+	expiry, serviceCode, discretionary, ok := splitTrailer(fields[1])
+
+	if !ok || !isNumeric(discretionary) {
+		return nil, ErrInvalidTrack2
+	}
+
+	return &Track2{
+		Pan:               fields[0],
+		Expiry:            expiry,
+		ServiceCode:       serviceCode,
+		DiscretionaryData: discretionary,
+	}, nil
+}
+
+// ParseTrack2EquivalentData parses the packed BCD track 2 equivalent data
+// of EMV tag 57, whose field separator is D and which is padded with F
+func ParseTrack2EquivalentData(data []byte) (*Track2, error) {
+	s := strings.TrimRight(strings.ToUpper(hex.EncodeToString(data)), "F")
+
+	return ParseTrack2([]byte(strings.Replace(s, "D", "=", 1)))
+}
+
+// trimSentinels strips the start sentinel, if present, and everything from
+// the end sentinel on. Without an end sentinel, trailing zero padding is
+// removed.
+func trimSentinels(data []byte, start byte) (string, bool) {
+	if len(data) > 0 && data[0] == start {
+		data = data[1:]
+	}
+
+	if end := bytes.IndexByte(data, '?'); end >= 0 {
+		data = data[:end]
+	} else {
+		data = bytes.TrimRight(data, "\x00")
+	}
+
+	for _, c := range data {
+		if c < 0x20 || c > 0x7E {
+			return "", false
+		}
+	}
+
+	return string(data), true
+}
+
+// splitTrailer splits the expiry date, service code and discretionary data
+// following the PAN, or the name on track 1
+func splitTrailer(s string) (string, string, string, bool) {
+	if len(s) < 7 || !isNumeric(s[:7]) {
+		return "", "", "", false
+	}
+
+	return s[:4], s[4:7], s[7:], true
+}
+
+func isPan(s string) bool {
+	return len(s) >= 12 && len(s) <= 19 && isNumeric(s)
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
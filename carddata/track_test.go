@@ -0,0 +1,54 @@
+package carddata
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTrack1(t *testing.T) {
+	track, err := ParseTrack1([]byte("%B5452300551227189^HOGAN/PAUL      ^08043210000000725000000?\x00\x00\x00\x00"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Track1{
+		FormatCode:        'B',
+		Pan:               "5452300551227189",
+		Name:              "HOGAN/PAUL",
+		Expiry:            "0804",
+		ServiceCode:       "321",
+		DiscretionaryData: "0000000725000000",
+	}, track)
+
+	track, err = ParseTrack1([]byte("B4111111111111111^DOE/JOHN^2512101"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "4111111111111111", track.Pan)
+	assert.Equal(t, "", track.DiscretionaryData)
+
+	_, err = ParseTrack1([]byte("%B5452300551227189^HOGAN/PAUL?"))
+	assert.Equal(t, ErrInvalidTrack1, err)
+
+	_, err = ParseTrack1([]byte("%B54523005512X7189^HOGAN/PAUL^0804321?"))
+	assert.Equal(t, ErrInvalidTrack1, err)
+}
+
+func TestParseTrack2(t *testing.T) {
+	track, err := ParseTrack2([]byte(";5413330089010434=25122010000000000000?5"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Track2{
+		Pan:               "5413330089010434",
+		Expiry:            "2512",
+		ServiceCode:       "201",
+		DiscretionaryData: "0000000000000",
+	}, track)
+
+	_, err = ParseTrack2([]byte(";5413330089010434D2512201?"))
+	assert.Equal(t, ErrInvalidTrack2, err)
+
+	data, _ := hex.DecodeString("5413330089010434d25122010000000000000f")
+	equivalent, err := ParseTrack2EquivalentData(data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, track, equivalent)
+}
@@ -26,7 +26,33 @@ func init() {
 	RegisterType(&cryptokit.Hmac{})
 	RegisterType(&cryptokit.Random{})
 	RegisterType(&cryptokit.Dukpt{})
+	RegisterType(&cryptokit.DukptIpek{})
+	RegisterType(&cryptokit.DukptKsnLayout{})
+	RegisterType(&cryptokit.DukptData{})
 	RegisterType(&cryptokit.FixedKey{})
+	RegisterType(&cryptokit.PinBlock{})
+	RegisterType(&cryptokit.CardVerificationValue{})
+	RegisterType(&cryptokit.Dcvv{})
+	RegisterType(&cryptokit.Cvc3{})
+	RegisterType(&cryptokit.Cavv{})
+	RegisterType(&cryptokit.Aav{})
+	RegisterType(&cryptokit.Pvv{})
+	RegisterType(&cryptokit.Ibm3624{})
+	RegisterType(&cryptokit.EmvIccMasterKey{})
+	RegisterType(&cryptokit.EmvSessionKey{})
+	RegisterType(&cryptokit.EmvCryptogram{})
+	RegisterType(&cryptokit.EmvArpc{})
+	RegisterType(&cryptokit.EmvSecureMessagingMac{})
+	RegisterType(&cryptokit.EmvSecureMessagingEncryption{})
+	RegisterType(&cryptokit.EmvPinChange{})
+	RegisterType(&cryptokit.Tr31{})
+	RegisterType(&cryptokit.Tr34{})
+	RegisterType(&cryptokit.KeyVariant{})
+	RegisterType(&cryptokit.RetailMac{})
+	RegisterType(&cryptokit.Cmac{})
+	RegisterType(&cryptokit.Ff1{})
+	RegisterType(&cryptokit.Ff31{})
+	RegisterType(&cryptokit.KeyDiversification{})
 
 	RegisterCommand("echo", func(e *echoArgs) (string, error) {
 		fmt.Printf("%s\n", e.Text)
@@ -23,12 +23,20 @@ type encryptArgs struct {
 	Out  io.Writer
 }
 
+type verifyArgs struct {
+	Mech      cryptokit.Mechanism `cmd:",primary"`
+	Key       cryptokit.Key
+	In        []byte
+	Signature []byte
+}
+
 type translateArgs struct {
-	Mech   cryptokit.Mechanism `cmd:",primary"`
-	InKey  cryptokit.Key
-	In     []byte
-	OutKey cryptokit.Key
-	Out    io.Writer
+	InMech  cryptokit.Mechanism `cmd:",primary"`
+	InKey   cryptokit.Key
+	In      []byte
+	OutMech cryptokit.Mechanism
+	OutKey  cryptokit.Key
+	Out     io.Writer
 }
 
 type wrapArgs struct {
@@ -90,6 +98,15 @@ type deriveArgs struct {
 	Key  cryptokit.Key
 }
 
+type workingKeyArgs struct {
+	keyAttributesArgs
+
+	Class  uint `cmd:",primary"`
+	Kek    cryptokit.Key
+	Format uint
+	Kcv    []byte
+}
+
 type hashArgs struct {
 	Mech cryptokit.Mechanism `cmd:",primary"`
 	In   []byte
@@ -165,7 +182,23 @@ func decrypt(a *encryptArgs) ([]byte, error) {
 }
 
 func translate(a *translateArgs) ([]byte, error) {
-	result, err := session.Translate(a.Mech, a.InKey, a.In, a.OutKey)
+	result, err := session.Translate(a.InMech, a.InKey, a.In, a.OutMech, a.OutKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Out == nil {
+		return result, nil
+	}
+
+	_, err = a.Out.Write(result)
+
+	return nil, err
+}
+
+func sign(a *encryptArgs) ([]byte, error) {
+	result, err := session.Sign(a.Mech, a.Key, a.In)
 
 	if err != nil {
 		return nil, err
@@ -180,6 +213,10 @@ func translate(a *translateArgs) ([]byte, error) {
 	return nil, err
 }
 
+func verify(a *verifyArgs) (bool, error) {
+	return session.Verify(a.Mech, a.Key, a.In, a.Signature)
+}
+
 func generate(a *generateArgs) (cryptokit.Key, error) {
 	return session.Generate(a.Mech, a.BuildAttributes())
 }
@@ -196,6 +233,27 @@ func derive(a *deriveArgs) (cryptokit.Key, error) {
 	return session.Derive(a.Mech, a.Key, a.BuildAttributes())
 }
 
+func generateWorkingKey(a *workingKeyArgs) (*cryptokit.ExchangedKey, error) {
+	attributes := a.BuildAttributes()
+
+	// Working keys default to the capabilities of their class
+	if len(a.Capabilities) == 0 {
+		attributes.Capabilities = 0
+	}
+
+	return cryptokit.GenerateWorkingKey(session, cryptokit.KeyClass(a.Class), a.Kek, cryptokit.KeyExchangeFormat(a.Format), attributes)
+}
+
+func importWorkingKey(a *workingKeyArgs) (cryptokit.Key, error) {
+	attributes := a.BuildAttributes()
+
+	if len(a.Capabilities) == 0 {
+		attributes.Capabilities = 0
+	}
+
+	return cryptokit.ImportWorkingKey(session, cryptokit.KeyClass(a.Class), a.Kek, cryptokit.KeyExchangeFormat(a.Format), a.In, a.Kcv, attributes)
+}
+
 func init() {
 	RegisterCommand("generate", generate)
 	RegisterCommand("find", findKey)
@@ -203,8 +261,12 @@ func init() {
 	RegisterCommand("hash", hash)
 	RegisterCommand("encrypt", encrypt)
 	RegisterCommand("decrypt", decrypt)
+	RegisterCommand("sign", sign)
+	RegisterCommand("verify", verify)
 	RegisterCommand("wrap", wrap)
 	RegisterCommand("unwrap", unwrap)
+	RegisterCommand("generate-working-key", generateWorkingKey)
+	RegisterCommand("import-working-key", importWorkingKey)
 }
 
 func main() {
@@ -0,0 +1,12 @@
+package cryptokit
+
+// Cmac generates and verifies NIST SP 800-38B CMACs through Session.Sign
+// and Session.Verify with an AES, DES or TDES key. Length, in bytes,
+// defaults to the cipher's block size.
+type Cmac struct {
+	Length int
+}
+
+func (m Cmac) Name() string {
+	return "cmac"
+}
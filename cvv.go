@@ -0,0 +1,34 @@
+package cryptokit
+
+type CardVerificationValueType uint
+
+const (
+	// CVV or CVC, computed with the card's service code
+	Cvv CardVerificationValueType = iota
+	// Printed CVV2 or CVC2, computed with service code 000
+	Cvv2
+	// Chip card iCVV, computed with service code 999
+	Icvv
+)
+
+// CardVerificationValue generates and verifies card verification values
+// with a double length CVK through Session.Sign and Session.Verify, the
+// input being ignored. Expiry is given as YYMM and Digits defaults to 3.
+type CardVerificationValue struct {
+	Pan         string `cmd:",primary"`
+	Expiry      string
+	ServiceCode string
+	Type        CardVerificationValueType
+	Digits      int
+}
+
+func (m CardVerificationValue) Name() string {
+	switch m.Type {
+	case Cvv2:
+		return "cvv2"
+	case Icvv:
+		return "icvv"
+	}
+
+	return "cvv"
+}
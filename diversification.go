@@ -0,0 +1,27 @@
+package cryptokit
+
+type DiversificationMethod uint
+
+const (
+	// EMV ICC master key derivation from Pan and PanSequenceNumber, Option
+	// selecting option A or B, as EmvIccMasterKey does
+	EmvDiversification DiversificationMethod = iota
+	// Data is enciphered in ECB mode under a TDES or AES master key, its
+	// length being that of the derived key
+	EcbDiversification
+)
+
+// KeyDiversification derives per-card keys from a master key through
+// Session.Derive. Derived keys can't be extractable, DES and TDES ones
+// having their parity adjusted.
+type KeyDiversification struct {
+	Method            DiversificationMethod `cmd:",primary"`
+	Pan               string
+	PanSequenceNumber string
+	Option            EmvDerivationOption
+	Data              []byte
+}
+
+func (m KeyDiversification) Name() string {
+	return "key-diversification"
+}
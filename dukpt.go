@@ -1,9 +1,89 @@
 package cryptokit
 
+type DukptAlgorithm uint
+
+const (
+	// ANSI X9.24-1 TDES DUKPT with 10 byte KSNs
+	DukptTdes DukptAlgorithm = iota
+	// ANSI X9.24-3 AES DUKPT with 12 byte KSNs
+	DukptAes
+)
+
+type DukptVariant uint
+
+const (
+	DukptPinVariant DukptVariant = iota
+	DukptMacRequestVariant
+	DukptMacResponseVariant
+	DukptDataRequestVariant
+	DukptDataResponseVariant
+)
+
+// DukptKsnLayout describes how TDES DUKPT KSNs are split between the key
+// set identifier and the device ID, both lengths in hex digits, and the
+// transaction counter. The zero value stands for the 10-5-5 layout with a 21
+// bit counter.
+type DukptKsnLayout struct {
+	KsiLength      int
+	DeviceIdLength int
+	CounterBits    int
+}
+
+// Dukpt derives the working key selected by Variant for Ksn, the PIN
+// encryption key by default, from a BDK or from the device's initial key.
+// When used in place of a block cipher it enciphers with Underlying under
+// the derived key, so it never has to be materialized as a Key. PIN keys
+// can only encipher PIN blocks, and only PIN blocks and DukptData need no
+// more than the Derive capability on the base key. Underlying
+// defaults to Tdes for TDES DUKPT and to Aes for AES DUKPT, whose working
+// keys take the type and length of the key they are derived into, or of the
+// BDK when used as a cipher.
 type Dukpt struct {
-	Ksn []byte `cmd:",primary"`
+	Ksn        []byte `cmd:",primary"`
+	Algorithm  DukptAlgorithm
+	Layout     DukptKsnLayout
+	Variant    DukptVariant
+	Underlying Mechanism
 }
 
 func (m Dukpt) Name() string {
-	return "dukpt"
+	if m.Underlying == nil {
+		return "dukpt"
+	}
+
+	return "dukpt-" + m.Underlying.Name()
+}
+
+// DukptIpek derives the initial key of the device identified by Ksn. The
+// derived key records its initial KSN as metadata and can be used by Dukpt
+// in place of the BDK, but only for that device.
+type DukptIpek struct {
+	Ksn       []byte `cmd:",primary"`
+	Algorithm DukptAlgorithm
+	Layout    DukptKsnLayout
+}
+
+func (m DukptIpek) Name() string {
+	return "dukpt-ipek"
+}
+
+// DukptData encrypts or decrypts with Underlying, a block cipher mode or
+// Gcm, under the data encryption key derived for Ksn, so a payload can be
+// processed in one call with the BDK or initial key. The request key is
+// used unless Response is set. Underlying defaults to CBC with the
+// algorithm's cipher.
+type DukptData struct {
+	Ksn        []byte `cmd:",primary"`
+	Algorithm  DukptAlgorithm
+	Layout     DukptKsnLayout
+	Response   bool
+	Underlying Mechanism
+}
+
+func (m DukptData) Name() string {
+	if m.Underlying == nil {
+		return "dukpt-data"
+	}
+
+	return "dukpt-data-" + m.Underlying.Name()
 }
@@ -0,0 +1,29 @@
+package cryptokit
+
+// Dcvv generates and verifies Visa dynamic CVVs through Session.Sign and
+// Session.Verify with the card's dCVV key, which can be derived from the
+// issuer master key with EmvIccMasterKey. The input is ignored, Expiry is
+// given as YYMM and Digits defaults to 3.
+type Dcvv struct {
+	Pan    string `cmd:",primary"`
+	Expiry string
+	Atc    []byte
+	Digits int
+}
+
+func (m Dcvv) Name() string {
+	return "dcvv"
+}
+
+// Cvc3 generates and verifies Mastercard CVC3s through Session.Sign and
+// Session.Verify with the card's CVC3 key, the input being the track data
+// the IVCVC3 is computed from. Digits defaults to 3.
+type Cvc3 struct {
+	UnpredictableNumber []byte `cmd:",primary"`
+	Atc                 []byte
+	Digits              int
+}
+
+func (m Cvc3) Name() string {
+	return "cvc3"
+}
@@ -0,0 +1,105 @@
+package cryptokit
+
+type EmvDerivationOption uint
+
+const (
+	EmvOptionA EmvDerivationOption = iota
+	// Option B only applies to PANs longer than 16 digits, option A being
+	// used otherwise
+	EmvOptionB
+)
+
+type EmvPadding uint
+
+const (
+	// ISO 9797-1 padding method 2, used with common session keys
+	EmvPaddingMethod2 EmvPadding = iota
+	// ISO 9797-1 padding method 1, used by Visa CVN 10
+	EmvPaddingMethod1
+)
+
+// EmvIccMasterKey derives the ICC master key of a card from an issuer master
+// key. PanSequenceNumber defaults to 00 and, like other diversified keys, ICC
+// master keys can't be extractable.
+type EmvIccMasterKey struct {
+	Pan               string `cmd:",primary"`
+	PanSequenceNumber string
+	Option            EmvDerivationOption
+}
+
+func (m EmvIccMasterKey) Name() string {
+	return "emv-icc-master-key"
+}
+
+// EmvSessionKey derives an EMV common session key from an ICC master key,
+// diversified by Atc or, when given, by the application cryptogram Ac.
+type EmvSessionKey struct {
+	Atc []byte `cmd:",primary"`
+	Ac  []byte
+}
+
+func (m EmvSessionKey) Name() string {
+	return "emv-session-key"
+}
+
+// EmvCryptogram generates and verifies application cryptograms over the
+// transaction data through Session.Sign and Session.Verify, using the ISO
+// 9797-1 MAC algorithm 3 with a session key or, for Visa CVN 10, the ICC
+// master key.
+type EmvCryptogram struct {
+	Padding EmvPadding
+}
+
+func (m EmvCryptogram) Name() string {
+	return "emv-cryptogram"
+}
+
+// EmvArpc generates and verifies the authorization response cryptogram of
+// Arqc through Session.Sign and Session.Verify, the input being ignored.
+// Method 1, the default, uses the 2 byte Arc and method 2 the 4 byte Csu
+// and up to 8 bytes of proprietary authentication data.
+type EmvArpc struct {
+	Arqc            []byte `cmd:",primary"`
+	Method          int
+	Arc             []byte
+	Csu             []byte
+	ProprietaryData []byte
+}
+
+func (m EmvArpc) Name() string {
+	return "emv-arpc"
+}
+
+// EmvSecureMessagingMac generates and verifies issuer script MACs through
+// Session.Sign and Session.Verify with the integrity session key, the input
+// being the command header, ATC, application cryptogram and command data.
+// Length, in bytes, defaults to 8.
+type EmvSecureMessagingMac struct {
+	Length int
+}
+
+func (m EmvSecureMessagingMac) Name() string {
+	return "emv-sm-mac"
+}
+
+// EmvSecureMessagingEncryption enciphers issuer script command data with the
+// confidentiality session key, in CBC mode unless Ecb is set.
+type EmvSecureMessagingEncryption struct {
+	Ecb bool
+}
+
+func (m EmvSecureMessagingEncryption) Name() string {
+	return "emv-sm-encryption"
+}
+
+// EmvPinChange is the output of PIN block translations producing the
+// enciphered PIN data of a PIN change script, a format 2 PIN block
+// enciphered as by EmvSecureMessagingEncryption. The confidentiality key
+// can't be allowed to decrypt, so the PIN never comes out in clear.
+type EmvPinChange struct {
+	Ecb bool
+}
+
+func (m EmvPinChange) Name() string {
+	return "emv-pin-change"
+}
@@ -0,0 +1,25 @@
+package cryptokit
+
+// Ff1 enciphers numeral strings, such as PANs, with NIST SP 800-38G FF1
+// under an AES key, the ciphertext keeping the length and alphabet of the
+// plaintext. Numerals are the digits followed by lowercase letters and
+// Radix, at most 36, defaults to 10.
+type Ff1 struct {
+	Radix int
+	Tweak []byte
+}
+
+func (m Ff1) Name() string {
+	return "ff1"
+}
+
+// Ff31 enciphers numeral strings like Ff1 with NIST SP 800-38G Rev. 1 FF3-1,
+// Tweak being 7 bytes long.
+type Ff31 struct {
+	Radix int
+	Tweak []byte
+}
+
+func (m Ff31) Name() string {
+	return "ff3-1"
+}
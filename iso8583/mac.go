@@ -0,0 +1,256 @@
+package iso8583
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"sort"
+
+	"github.com/pagarme/cryptokit"
+)
+
+type Convention int
+
+const (
+	// MTI, bitmaps and every field preceding the MAC field, as they are
+	// sent, the bitmap already flagging the MAC field
+	FullMessage Convention = iota
+	// The listed fields present in the message, in bitmap order
+	SelectedFields
+	// The listed fields present in the message, in bitmap order, each
+	// reduced to the ANSI X9.19 message authentication character set and
+	// separated by a space
+	SelectedFieldsText
+)
+
+var (
+	ErrInvalidField = errors.New("Invalid ISO 8583 field number")
+	ErrMissingMac   = errors.New("Message has no MAC")
+)
+
+// Message holds the MTI and field data of a message as they are sent,
+// variable length fields including their length prefix
+type Message struct {
+	Mti    []byte
+	Fields map[int][]byte
+}
+
+// MacScheme describes how a network MACs messages. Mechanism is used with
+// Session.Sign and Session.Verify, typically cryptokit.RetailMac or
+// cryptokit.Cmac. MacField defaults to 128 when the message has a
+// secondary bitmap and to 64 otherwise, and Length, the size of the MAC
+// field, to 8 bytes.
+type MacScheme struct {
+	Convention Convention
+	Fields     []int
+	Mechanism  cryptokit.Mechanism
+	MacField   int
+	Length     int
+}
+
+// Bitmap returns the primary bitmap of the message, followed by the
+// secondary one when fields above 64 are present
+func (m *Message) Bitmap() []byte {
+	bitmap := make([]byte, 8)
+
+	for field := range m.Fields {
+		if field > 64 && len(bitmap) == 8 {
+			bitmap = append(bitmap, make([]byte, 8)...)
+			bitmap[0] |= 0x80
+		}
+	}
+
+	for field := range m.Fields {
+		if field >= 2 && field <= len(bitmap)*8 {
+			bitmap[(field-1)/8] |= 0x80 >> uint((field-1)%8)
+		}
+	}
+
+	return bitmap
+}
+
+// Input returns the data MACed under the scheme's convention. Bitmaps are
+// built from the fields, so only fields 2 to 128 can be set.
+func (s MacScheme) Input(m *Message) ([]byte, error) {
+	macField := s.macField(m)
+	var result []byte
+
+	if err := checkFields(m, macField); err != nil {
+		return nil, err
+	}
+
+	switch s.Convention {
+	case FullMessage:
+		fields := make(map[int][]byte, len(m.Fields)+1)
+
+		for field, data := range m.Fields {
+			fields[field] = data
+		}
+
+		// The MAC field is flagged even before the MAC is set
+		fields[macField] = nil
+
+		result = append(result, m.Mti...)
+		result = append(result, (&Message{Fields: fields}).Bitmap()...)
+
+		for _, field := range sortedFields(m.Fields) {
+			if field < macField {
+				result = append(result, m.Fields[field]...)
+			}
+		}
+	case SelectedFields, SelectedFieldsText:
+		selected := make(map[int][]byte)
+
+		for _, field := range s.Fields {
+			if field < 2 || field > 128 || field == macField {
+				return nil, ErrInvalidField
+			}
+
+			if data, ok := m.Fields[field]; ok {
+				selected[field] = data
+			}
+		}
+
+		for i, field := range sortedFields(selected) {
+			if s.Convention == SelectedFields {
+				result = append(result, selected[field]...)
+				continue
+			}
+
+			if i > 0 {
+				result = append(result, ' ')
+			}
+
+			result = append(result, Canonicalize(selected[field])...)
+		}
+	default:
+		return nil, errors.New("Unknown MAC convention")
+	}
+
+	return result, nil
+}
+
+// Generate computes the MAC of the message and stores it in the MAC field
+func (s MacScheme) Generate(session cryptokit.Session, key cryptokit.Key, m *Message) ([]byte, error) {
+	mac, err := s.compute(session, key, m)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Fields == nil {
+		m.Fields = make(map[int][]byte)
+	}
+
+	m.Fields[s.macField(m)] = mac
+
+	return mac, nil
+}
+
+// Verify checks the MAC field of the message
+func (s MacScheme) Verify(session cryptokit.Session, key cryptokit.Key, m *Message) (bool, error) {
+	if err := checkFields(m, s.macField(m)); err != nil {
+		return false, err
+	}
+
+	received, ok := m.Fields[s.macField(m)]
+
+	if !ok {
+		return false, ErrMissingMac
+	}
+
+	expected, err := s.compute(session, key, m)
+
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare(expected, received) == 1, nil
+}
+
+func (s MacScheme) compute(session cryptokit.Session, key cryptokit.Key, m *Message) ([]byte, error) {
+	input, err := s.Input(m)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := session.Sign(s.Mechanism, key, input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	length := s.Length
+
+	if length == 0 {
+		length = 8
+	}
+
+	if length > len(mac) {
+		return nil, errors.New("Invalid MAC length")
+	}
+
+	return mac[:length], nil
+}
+
+func (s MacScheme) macField(m *Message) int {
+	if s.MacField != 0 {
+		return s.MacField
+	}
+
+	for field := range m.Fields {
+		if field > 64 {
+			return 128
+		}
+	}
+
+	return 64
+}
+
+// Canonicalize reduces field data to the ANSI X9.19 message authentication
+// character set: letters are uppercased, characters other than letters,
+// digits, spaces, commas and periods are dropped and runs of spaces are
+// collapsed, leading and trailing ones being removed
+func Canonicalize(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+
+	for _, c := range bytes.ToUpper(data) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ',', c == '.':
+			result = append(result, c)
+		case c == ' ':
+			if len(result) > 0 && result[len(result)-1] != ' ' {
+				result = append(result, c)
+			}
+		}
+	}
+
+	return bytes.TrimRight(result, " ")
+}
+
+func checkFields(m *Message, macField int) error {
+	if macField < 2 || macField > 128 {
+		return ErrInvalidField
+	}
+
+	for field := range m.Fields {
+		if field < 2 || field > 128 {
+			return ErrInvalidField
+		}
+	}
+
+	return nil
+}
+
+func sortedFields(fields map[int][]byte) []int {
+	result := make([]int, 0, len(fields))
+
+	for field := range fields {
+		result = append(result, field)
+	}
+
+	sort.Ints(result)
+
+	return result
+}
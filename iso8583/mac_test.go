@@ -0,0 +1,151 @@
+package iso8583
+
+import (
+	"encoding/hex"
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+var testMasterKey = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+
+func newTestMessage() *Message {
+	return &Message{
+		Mti: []byte("0200"),
+		Fields: map[int][]byte{
+			3:  []byte("000000"),
+			4:  []byte("000000010000"),
+			11: []byte("000001"),
+			41: []byte("TERM0001"),
+			43: []byte("acme   store, são paulo! "),
+		},
+	}
+}
+
+func TestBitmap(t *testing.T) {
+	m := newTestMessage()
+
+	assert.Equal(t, "3020000000a00000", hex.EncodeToString(m.Bitmap()))
+
+	m.Fields[90] = []byte("0")
+
+	assert.Equal(t, "b020000000a000000000004000000000", hex.EncodeToString(m.Bitmap()))
+}
+
+func TestCanonicalize(t *testing.T) {
+	assert.Equal(t, "ACME STORE, SO PAULO", string(Canonicalize([]byte("  acme   store, são paulo! "))))
+}
+
+func TestMac(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := soft.New("testdb.db", testMasterKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	keyData, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+
+	key, err := s.Generate(cryptokit.FixedKey{Key: keyData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err)
+
+	full := MacScheme{
+		Convention: FullMessage,
+		Mechanism:  cryptokit.RetailMac{},
+	}
+
+	m := newTestMessage()
+	delete(m.Fields, 43)
+
+	mac, err := full.Generate(s, key, m)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "80718ccc0855ded1", hex.EncodeToString(mac))
+	assert.Equal(t, mac, m.Fields[64])
+
+	ok, err := full.Verify(s, key, m)
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	m.Fields[4] = []byte("000000020000")
+
+	ok, err = full.Verify(s, key, m)
+
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	selected := MacScheme{
+		Convention: SelectedFieldsText,
+		Fields:     []int{43, 4, 41, 52},
+		Mechanism:  cryptokit.RetailMac{},
+		Length:     4,
+	}
+
+	m = newTestMessage()
+
+	input, err := selected.Input(m)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "000000010000 TERM0001 ACME STORE, SO PAULO", string(input))
+
+	mac, err = selected.Generate(s, key, m)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(mac))
+
+	ok, err = selected.Verify(s, key, m)
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// CMAC works the same way
+	cmac := MacScheme{
+		Convention: SelectedFields,
+		Fields:     []int{3, 4, 11},
+		Mechanism:  cryptokit.Cmac{},
+	}
+
+	m = newTestMessage()
+
+	_, err = cmac.Generate(s, key, m)
+
+	assert.Nil(t, err)
+
+	ok, err = cmac.Verify(s, key, m)
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = cmac.Verify(s, key, newTestMessage())
+
+	assert.Equal(t, ErrMissingMac, err)
+
+	// Bitmaps can't be set as fields
+	m = newTestMessage()
+	m.Fields[1] = make([]byte, 8)
+
+	_, err = full.Generate(s, key, m)
+
+	assert.Equal(t, ErrInvalidField, err)
+
+	m = newTestMessage()
+
+	_, err = full.Generate(s, key, m)
+
+	assert.Nil(t, err)
+
+	m.Fields[129] = []byte("0")
+
+	_, err = full.Verify(s, key, m)
+
+	assert.Equal(t, ErrInvalidField, err)
+}
@@ -7,9 +7,9 @@ const (
 	AesKey          = 1
 	DesKey          = 2
 	TdesKey         = 3
-	RsaKey          = 3
 	DsaKey          = 4
 	RawKey          = 5
+	RsaKey          = 6
 )
 
 type KeyCapability uint
@@ -21,9 +21,23 @@ const (
 	Wrap                  = 0x4
 	Unwrap                = 0x8
 	Derive                = 0x10
+	Sign                  = 0x20
+	Verify                = 0x40
 
 	EncryptDecrypt  = Encrypt | Decrypt
-	AllCapabilities = Encrypt | Decrypt | Wrap | Unwrap | Derive
+	SignVerify      = Sign | Verify
+	AllCapabilities = Encrypt | Decrypt | Wrap | Unwrap | Derive | Sign | Verify
+)
+
+// Metadata keys set by providers
+const (
+	// Hex encoded initial KSN of DUKPT initial keys
+	DukptInitialKsnMetadata = "dukpt-initial-ksn"
+	// TR-31 key usage of keys imported from key blocks
+	Tr31KeyUsageMetadata = "tr31-key-usage"
+	// TR-31 exportability of keys imported from key blocks, only "E" keys
+	// can be wrapped again
+	Tr31ExportabilityMetadata = "tr31-exportability"
 )
 
 type KeyAttributes struct {
@@ -33,6 +47,7 @@ type KeyAttributes struct {
 	Permanent    bool
 	Extractable  bool
 	Capabilities KeyCapability
+	Metadata     map[string]string
 }
 
 type Key interface {
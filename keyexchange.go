@@ -0,0 +1,155 @@
+package cryptokit
+
+import (
+	"bytes"
+	"errors"
+)
+
+type KeyExchangeFormat uint
+
+const (
+	// Key enciphered with the key length variants, see KeyVariant
+	VariantFormat KeyExchangeFormat = iota
+	// Key enciphered as ANSI X9.17 does, see KeyVariant
+	X917Format
+	// TR-31 key block carrying its KCV
+	Tr31Format
+)
+
+var ErrKcvMismatch = errors.New("Key check value doesn't match")
+
+// ExchangedKey is a working key along with its form enciphered under a key
+// encrypting key, typically a ZMK, and its check value
+type ExchangedKey struct {
+	Key       Key
+	Encrypted []byte
+	Kcv       []byte
+}
+
+var keyClassUsages = map[KeyClass]string{
+	ZmkClass: "K0",
+	ZpkClass: "P0",
+	TakClass: "M3",
+	CvkClass: "C0",
+}
+
+var keyClassCapabilities = map[KeyClass]KeyCapability{
+	ZmkClass: Wrap | Unwrap,
+	ZpkClass: EncryptDecrypt,
+	TakClass: SignVerify,
+	CvkClass: SignVerify,
+}
+
+// GenerateWorkingKey generates a key of the given class and exports it
+// under kek. Attributes left zero default to a double length TDES key with
+// the class's capabilities, which only leaves the provider enciphered.
+func GenerateWorkingKey(s Session, class KeyClass, kek Key, format KeyExchangeFormat, attributes KeyAttributes) (*ExchangedKey, error) {
+	mech, err := getKeyExchangeMechanism(class, format)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err = getWorkingKeyAttributes(class, attributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.Generate(Random{}, attributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := s.Wrap(mech, kek, key)
+
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	kcv, err := KeyCheckValue(s, key)
+
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	return &ExchangedKey{
+		Key:       key,
+		Encrypted: encrypted,
+		Kcv:       kcv,
+	}, nil
+}
+
+// ImportWorkingKey imports a key of the given class enciphered under kek,
+// checking it against kcv, which may be truncated
+func ImportWorkingKey(s Session, class KeyClass, kek Key, format KeyExchangeFormat, encrypted, kcv []byte, attributes KeyAttributes) (Key, error) {
+	mech, err := getKeyExchangeMechanism(class, format)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err = getWorkingKeyAttributes(class, attributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.Unwrap(mech, kek, encrypted, attributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := KeyCheckValue(s, key)
+
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	if len(kcv) == 0 || len(kcv) > len(actual) || !bytes.Equal(actual[:len(kcv)], kcv) {
+		key.Destroy()
+		return nil, ErrKcvMismatch
+	}
+
+	return key, nil
+}
+
+func getWorkingKeyAttributes(class KeyClass, attributes KeyAttributes) (KeyAttributes, error) {
+	capabilities, ok := keyClassCapabilities[class]
+
+	if !ok {
+		return attributes, errors.New("Unknown key class")
+	}
+
+	if attributes.Type == 0 {
+		attributes.Type = TdesKey
+	}
+
+	if attributes.Length == 0 {
+		attributes.Length = 16
+	}
+
+	if attributes.Capabilities == 0 {
+		attributes.Capabilities = capabilities
+	}
+
+	return attributes, nil
+}
+
+func getKeyExchangeMechanism(class KeyClass, format KeyExchangeFormat) (Mechanism, error) {
+	switch format {
+	case VariantFormat:
+		return KeyVariant{Class: class}, nil
+	case X917Format:
+		return KeyVariant{Class: class, X917: true}, nil
+	case Tr31Format:
+		return Tr31{KeyUsage: keyClassUsages[class], IncludeKcv: true}, nil
+	}
+
+	return nil, errors.New("Unknown key exchange format")
+}
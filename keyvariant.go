@@ -0,0 +1,30 @@
+package cryptokit
+
+type KeyClass uint
+
+const (
+	ZmkClass KeyClass = iota
+	ZpkClass
+	TakClass
+	CvkClass
+)
+
+// KeyVariant wraps and unwraps keys in the legacy variant format of
+// Thales-style hosts: each 8 byte part of the key is enciphered in ECB mode
+// under the wrapping key XORed with the key length variant of the part. X917
+// omits the key length variants, as ANSI X9.17 key exchanges do.
+//
+// Set Lmk when the wrapping key is the LMK pair of the key's Class (04-05
+// for ZMKs, 06-07 for ZPKs, 16-17 for TAKs and 14-15 for CVKs), so the
+// Thales key type variant of the Class is applied as well: variant 4 for
+// CVKs and variant 0 for the others. Keys exchanged under a ZMK carry no
+// key type variant.
+type KeyVariant struct {
+	Class KeyClass `cmd:",primary"`
+	X917  bool
+	Lmk   bool
+}
+
+func (m KeyVariant) Name() string {
+	return "key-variant"
+}
@@ -0,0 +1,14 @@
+package cryptokit
+
+// PinBlock enciphers ISO 9564 PIN blocks. Format is the ISO 9564 format
+// number (0, 1, 3 or 4) and Underlying is the block cipher used, Tdes for
+// formats 0, 1 and 3 and Aes for format 4.
+type PinBlock struct {
+	Underlying Mechanism `cmd:",primary"`
+	Format     int
+	Pan        string
+}
+
+func (m PinBlock) Name() string {
+	return m.Underlying.Name() + "-pinblock"
+}
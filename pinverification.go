@@ -0,0 +1,32 @@
+package cryptokit
+
+// Pvv generates and verifies Visa PIN verification values through
+// Session.Sign and Session.Verify with a double length PVK. The input is a
+// PIN block described by PinBlock and encrypted under PinKey, whose PAN is
+// also used by the PVV.
+type Pvv struct {
+	PinBlock Mechanism `cmd:",primary"`
+	PinKey   Key
+	Pvki     int
+}
+
+func (m Pvv) Name() string {
+	return "pvv"
+}
+
+// Ibm3624 generates and verifies IBM 3624 PIN offsets through Session.Sign
+// and Session.Verify with a single or double length PVK, the input being a
+// PIN block as in Pvv. Natural PINs have an all zero offset. The
+// decimalization table defaults to 0123456789012345 and CheckLength, the
+// number of PIN digits verified, to 4.
+type Ibm3624 struct {
+	PinBlock            Mechanism `cmd:",primary"`
+	PinKey              Key
+	ValidationData      string
+	DecimalizationTable string
+	CheckLength         int
+}
+
+func (m Ibm3624) Name() string {
+	return "ibm3624"
+}
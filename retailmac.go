@@ -0,0 +1,14 @@
+package cryptokit
+
+// RetailMac generates and verifies ANSI X9.19 retail MACs, ISO 9797-1 MAC
+// algorithm 3, through Session.Sign and Session.Verify with a double length
+// TDES key. Data is padded with zeros unless Method2 selects ISO 9797-1
+// padding method 2. Length, in bytes, defaults to 8.
+type RetailMac struct {
+	Method2 bool
+	Length  int
+}
+
+func (m RetailMac) Name() string {
+	return "retail-mac"
+}
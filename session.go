@@ -1,12 +1,19 @@
 package cryptokit
 
+import (
+	"errors"
+)
+
 type Session interface {
 	ListKeys() ([]string, error)
 	FindKey(id string) (Key, bool, error)
 
 	Encrypt(mech Mechanism, key Key, in []byte) ([]byte, error)
 	Decrypt(mech Mechanism, key Key, in []byte) ([]byte, error)
-	Translate(mech Mechanism, inKey Key, in []byte, outKey Key) ([]byte, error)
+	Translate(inMech Mechanism, inKey Key, in []byte, outMech Mechanism, outKey Key) ([]byte, error)
+
+	Sign(mech Mechanism, key Key, in []byte) ([]byte, error)
+	Verify(mech Mechanism, key Key, in, signature []byte) (bool, error)
 
 	Wrap(mech Mechanism, kek, key Key) ([]byte, error)
 	Unwrap(mech Mechanism, kek Key, key []byte, attributes KeyAttributes) (Key, error)
@@ -18,3 +25,22 @@ type Session interface {
 
 	Close() error
 }
+
+// KeyCheckValuer is implemented by sessions able to compute the check value
+// of a block cipher key, the first 3 bytes of a block of zeros enciphered
+// under DES and TDES keys and the first 5 bytes of the CMAC of a block of
+// zeros for AES keys
+type KeyCheckValuer interface {
+	KeyCheckValue(key Key) ([]byte, error)
+}
+
+// KeyCheckValue returns the check value of key, if the session supports it
+func KeyCheckValue(s Session, key Key) ([]byte, error) {
+	v, ok := s.(KeyCheckValuer)
+
+	if !ok {
+		return nil, errors.New("Session can't compute key check values")
+	}
+
+	return v.KeyCheckValue(key)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 
 	"github.com/boltdb/bolt"
@@ -26,6 +27,14 @@ func newBoltDatabase(path string, key []byte) (*boltDatabase, error) {
 			return err
 		}
 
+		if _, err := tx.CreateBucketIfNotExists([]byte("counters")); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists([]byte("routes")); err != nil {
+			return err
+		}
+
 		return nil
 	})
 
@@ -132,6 +141,93 @@ func (b *boltDatabase) Remove(id string) error {
 	})
 }
 
+func (b *boltDatabase) FindCounter(device string) (uint64, bool, error) {
+	var counter uint64
+	var found bool
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("counters"))
+
+		if bytes := bkt.Get([]byte(device)); len(bytes) == 8 {
+			counter = binary.BigEndian.Uint64(bytes)
+			found = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return counter, found, nil
+}
+
+func (b *boltDatabase) AdvanceCounter(device string, counter uint64) (uint64, bool, error) {
+	var previous uint64
+	var advanced bool
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("counters"))
+
+		if bytes := bkt.Get([]byte(device)); len(bytes) == 8 {
+			previous = binary.BigEndian.Uint64(bytes)
+
+			if counter <= previous {
+				return nil
+			}
+		}
+
+		advanced = true
+
+		bytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(bytes, counter)
+
+		return bkt.Put([]byte(device), bytes)
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return previous, advanced, nil
+}
+
+func (b *boltDatabase) SaveRoute(ksi string, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("routes"))
+
+		return bkt.Put([]byte(ksi), []byte(id))
+	})
+}
+
+func (b *boltDatabase) FindRoute(ksi string) (string, bool, error) {
+	var id []byte
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("routes"))
+
+		// Values are only valid during the transaction
+		id = append([]byte{}, bkt.Get([]byte(ksi))...)
+
+		return nil
+	})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(id), len(id) > 0, nil
+}
+
+func (b *boltDatabase) RemoveRoute(ksi string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("routes"))
+
+		return bkt.Delete([]byte(ksi))
+	})
+}
+
 func (b *boltDatabase) Close() error {
 	return b.db.Close()
 }
@@ -0,0 +1,116 @@
+package card
+
+import (
+	"crypto/des"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	Cvv2ServiceCode = "000"
+	IcvvServiceCode = "999"
+)
+
+var (
+	ErrInvalidPan         = errors.New("Invalid PAN")
+	ErrInvalidExpiry      = errors.New("Invalid expiry date")
+	ErrInvalidServiceCode = errors.New("Invalid service code")
+	ErrInvalidCvk         = errors.New("CVKs must be double length DES keys")
+	ErrInvalidDigits      = errors.New("Invalid number of digits")
+)
+
+// Cvv computes the Visa CVV, Mastercard CVC and their CVV2 and iCVV
+// counterparts, which only differ by the service code. The expiry date is
+// given as YYMM and digits defaults to 3.
+func Cvv(cvk []byte, pan, expiry, serviceCode string, digits int) (string, error) {
+	if len(cvk) != 16 {
+		return "", ErrInvalidCvk
+	}
+
+	if len(pan) < 12 || len(pan) > 19 || !isNumeric(pan) {
+		return "", ErrInvalidPan
+	}
+
+	if len(expiry) != 4 || !isNumeric(expiry) {
+		return "", ErrInvalidExpiry
+	}
+
+	if len(serviceCode) != 3 || !isNumeric(serviceCode) {
+		return "", ErrInvalidServiceCode
+	}
+
+	return computeCvv(cvk, pan+expiry+serviceCode, digits)
+}
+
+// computeCvv enciphers the hex digits of data, right padded with zeros to
+// 32 digits, and decimalizes the result
+func computeCvv(cvk []byte, data string, digits int) (string, error) {
+	if digits == 0 {
+		digits = 3
+	}
+
+	if digits < 0 || digits > 16 {
+		return "", ErrInvalidDigits
+	}
+
+	data += strings.Repeat("0", 32-len(data))
+
+	raw, _ := hex.DecodeString(data)
+
+	left, err := des.NewCipher(cvk[:8])
+
+	if err != nil {
+		return "", err
+	}
+
+	right, err := des.NewCipher(cvk[8:])
+
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]byte, 8)
+
+	left.Encrypt(result, raw[:8])
+
+	for i := range result {
+		result[i] ^= raw[8+i]
+	}
+
+	left.Encrypt(result, result)
+	right.Decrypt(result, result)
+	left.Encrypt(result, result)
+
+	return decimalize(hex.EncodeToString(result), digits), nil
+}
+
+// decimalize extracts the decimal digits of a hex string left to right,
+// followed by its letters converted to digits
+func decimalize(data string, digits int) string {
+	result := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); i++ {
+		if data[i] <= '9' {
+			result = append(result, data[i])
+		}
+	}
+
+	for i := 0; i < len(data); i++ {
+		if data[i] > '9' {
+			result = append(result, data[i]-'a'+'0')
+		}
+	}
+
+	return string(result[:digits])
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
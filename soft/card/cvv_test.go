@@ -0,0 +1,41 @@
+package card
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testCvk, _ = hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+func TestCvv(t *testing.T) {
+	cvv, err := Cvv(testCvk, "4123456789012345", "8701", "101", 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "561", cvv)
+}
+
+func TestCvvValidation(t *testing.T) {
+	cvv2, err := Cvv(testCvk, "4123456789012345", "8701", Cvv2ServiceCode, 0)
+
+	assert.Nil(t, err)
+	assert.Len(t, cvv2, 3)
+	assert.NotEqual(t, "561", cvv2)
+
+	long, err := Cvv(testCvk, "4123456789012345", "8701", "101", 5)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "561", long[:3])
+
+	_, err = Cvv(testCvk[:8], "4123456789012345", "8701", "101", 0)
+	assert.Equal(t, ErrInvalidCvk, err)
+
+	_, err = Cvv(testCvk, "41234567890A2345", "8701", "101", 0)
+	assert.Equal(t, ErrInvalidPan, err)
+
+	_, err = Cvv(testCvk, "4123456789012345", "870", "101", 0)
+	assert.Equal(t, ErrInvalidExpiry, err)
+
+	_, err = Cvv(testCvk, "4123456789012345", "8701", "1010", 0)
+	assert.Equal(t, ErrInvalidServiceCode, err)
+}
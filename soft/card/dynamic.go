@@ -0,0 +1,95 @@
+package card
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/pagarme/cryptokit/soft/tdes"
+)
+
+var (
+	ErrInvalidAtc                 = errors.New("Invalid ATC")
+	ErrInvalidUnpredictableNumber = errors.New("Invalid unpredictable number")
+)
+
+// Dcvv computes the Visa dynamic CVV of a contactless magnetic stripe
+// transaction, the CVV algorithm with the service code replaced by the 2
+// byte ATC, under the card's dCVV key. Digits defaults to 3.
+func Dcvv(key []byte, pan, expiry string, atc []byte, digits int) (string, error) {
+	if len(key) != 16 {
+		return "", ErrInvalidCvk
+	}
+
+	if len(pan) < 12 || len(pan) > 19 || !isNumeric(pan) {
+		return "", ErrInvalidPan
+	}
+
+	if len(expiry) != 4 || !isNumeric(expiry) {
+		return "", ErrInvalidExpiry
+	}
+
+	if len(atc) != 2 {
+		return "", ErrInvalidAtc
+	}
+
+	return computeCvv(key, pan+expiry+hex.EncodeToString(atc), digits)
+}
+
+// Cvc3 computes the Mastercard CVC3 of a contactless magnetic stripe
+// transaction under the card's CVC3 key. The IVCVC3 is the 2 rightmost
+// bytes of the TDES CBC-MAC of the zero padded track data, which is
+// enciphered along with the 4 byte unpredictable number and the 2 byte ATC.
+// The CVC3 is made of the digits rightmost decimal digits, 3 by default, of
+// the 2 rightmost bytes of the result.
+func Cvc3(key, track, un, atc []byte, digits int) (string, error) {
+	if len(key) != 16 {
+		return "", ErrInvalidCvk
+	}
+
+	if len(un) != 4 {
+		return "", ErrInvalidUnpredictableNumber
+	}
+
+	if len(atc) != 2 {
+		return "", ErrInvalidAtc
+	}
+
+	if digits == 0 {
+		digits = 3
+	}
+
+	if digits < 0 || digits > 5 {
+		return "", ErrInvalidDigits
+	}
+
+	block, err := tdes.NewCipher(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	padded := make([]byte, (len(track)+7)/8*8)
+	copy(padded, track)
+
+	if len(padded) == 0 {
+		padded = make([]byte, 8)
+	}
+
+	mac := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, make([]byte, 8)).CryptBlocks(mac, padded)
+
+	data := make([]byte, 8)
+
+	copy(data, mac[len(mac)-2:])
+	copy(data[2:], un)
+	copy(data[6:], atc)
+
+	block.Encrypt(data, data)
+
+	value := fmt.Sprintf("%05d", binary.BigEndian.Uint16(data[6:]))
+
+	return value[5-digits:], nil
+}
@@ -0,0 +1,51 @@
+package card
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testTrack, _ = hex.DecodeString("5413330089010434d25122010000000000000f")
+
+func TestDcvv(t *testing.T) {
+	// Expected values computed outside Go, with OpenSSL's des-ede3
+	dcvv, err := Dcvv(testCvk, "4123456789012345", "8701", []byte{0x00, 0x1C}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "676", dcvv)
+
+	dcvv, err = Dcvv(testCvk, "5413330089010434", "2512", []byte{0x01, 0x02}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "186", dcvv)
+
+	_, err = Dcvv(testCvk, "4123456789012345", "8701", []byte{0x1C}, 0)
+	assert.Equal(t, ErrInvalidAtc, err)
+}
+
+func TestCvc3(t *testing.T) {
+	un := []byte{0x00, 0x00, 0x12, 0x34}
+
+	// Expected values computed outside Go, with OpenSSL's des-ede3
+	cvc3, err := Cvc3(testCvk, testTrack, un, []byte{0x00, 0x1C}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "863", cvc3)
+
+	cvc3, err = Cvc3(testCvk, testTrack, un, []byte{0x00, 0x1C}, 5)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "28863", cvc3)
+
+	cvc3, err = Cvc3(testCvk, testTrack, []byte{0x89, 0xAB, 0xCD, 0xEF}, []byte{0x01, 0x02}, 5)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "16918", cvc3)
+
+	_, err = Cvc3(testCvk, testTrack, un[:3], []byte{0x00, 0x1C}, 0)
+	assert.Equal(t, ErrInvalidUnpredictableNumber, err)
+
+	_, err = Cvc3(testCvk, testTrack, un, []byte{0x00, 0x1C}, 6)
+	assert.Equal(t, ErrInvalidDigits, err)
+}
@@ -0,0 +1,61 @@
+package card
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+)
+
+const aavMacLength = 5
+
+var (
+	ErrInvalidAtn                = errors.New("Invalid authentication tracking number")
+	ErrInvalidAuthenticationCode = errors.New("Invalid authentication results or second factor code")
+)
+
+// Cavv computes the 3 digit CAVV output of a Visa 3-D Secure
+// authentication with the CVV with ATN algorithm: the CVV algorithm under
+// the CAVV key, with the last 4 digits of the authentication tracking
+// number in place of the expiry date and the 1 digit authentication results
+// code followed by the 2 digit second factor authentication code in place
+// of the service code.
+func Cavv(key []byte, pan, atn, resultsCode, secondFactorCode string) (string, error) {
+	if len(key) != 16 {
+		return "", ErrInvalidCvk
+	}
+
+	if len(pan) < 12 || len(pan) > 19 || !isNumeric(pan) {
+		return "", ErrInvalidPan
+	}
+
+	if len(atn) < 4 || !isNumeric(atn) {
+		return "", ErrInvalidAtn
+	}
+
+	code := resultsCode + secondFactorCode
+
+	if len(resultsCode) != 1 || len(secondFactorCode) != 2 || !isNumeric(code) {
+		return "", ErrInvalidAuthenticationCode
+	}
+
+	return computeCvv(key, pan+atn[len(atn)-4:]+code, 3)
+}
+
+// Aav computes the 5 byte MAC of a Mastercard 3-D Secure AAV with the HMAC
+// algorithm, over the PAN, packed BCD right padded with F to 20 digits,
+// followed by the AAV fields preceding the MAC.
+func Aav(key []byte, h func() hash.Hash, pan string, data []byte) ([]byte, error) {
+	if len(pan) < 12 || len(pan) > 19 || !isNumeric(pan) {
+		return nil, ErrInvalidPan
+	}
+
+	packed, _ := hex.DecodeString(pan + strings.Repeat("F", 20-len(pan)))
+
+	mac := hmac.New(h, key)
+	mac.Write(packed)
+	mac.Write(data)
+
+	return mac.Sum(nil)[:aavMacLength], nil
+}
@@ -0,0 +1,44 @@
+package card
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testAavKey, _ = hex.DecodeString("00112233445566778899aabbccddeeff00112233")
+var testAavData, _ = hex.DecodeString("8c0102030405060708090a0b0c0d0e")
+
+func TestCavv(t *testing.T) {
+	// The same CVV algorithm inputs as TestCvv
+	cavv, err := Cavv(testCvk, "4123456789012345", "1234567890128701", "1", "01")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "561", cavv)
+
+	_, err = Cavv(testCvk, "4123456789012345", "701", "1", "01")
+	assert.Equal(t, ErrInvalidAtn, err)
+
+	_, err = Cavv(testCvk, "4123456789012345", "1234567890128701", "10", "1")
+	assert.Equal(t, ErrInvalidAuthenticationCode, err)
+
+	_, err = Cavv(testCvk, "4123456789012345", "1234567890128701", "A", "01")
+	assert.Equal(t, ErrInvalidAuthenticationCode, err)
+}
+
+func TestAav(t *testing.T) {
+	mac, err := Aav(testAavKey, sha1.New, "5412345678901234", testAavData)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "c5c928f08e", hex.EncodeToString(mac))
+
+	mac, err = Aav(testAavKey, sha256.New, "5412345678901234", testAavData)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1152af2a00", hex.EncodeToString(mac))
+
+	_, err = Aav(testAavKey, sha1.New, "541234567890123X", testAavData)
+	assert.Equal(t, ErrInvalidPan, err)
+}
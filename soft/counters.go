@@ -0,0 +1,170 @@
+package soft
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/dukpt"
+)
+
+var ErrKsnReplayed = errors.New("KSN counter isn't greater than the last one seen")
+
+type DukptCounterEventKind int
+
+const (
+	// The KSN counter wasn't greater than the last one seen for the device
+	DukptCounterReplayed DukptCounterEventKind = iota
+	// The device is about to exhaust its transaction counter
+	DukptCounterExhausting
+)
+
+type DukptCounterEvent struct {
+	Kind DukptCounterEventKind
+	// The device's initial KSN, or initial key ID for AES DUKPT
+	Device   []byte
+	Counter  uint64
+	Previous uint64
+}
+
+// DukptCounterTracker records the last transaction counter of each device
+// whose KSNs are used to decrypt, translate or derive request keys,
+// detecting replayed and regressed KSNs. Rejected KSNs are refused before
+// the operation runs, but counters are only recorded once it succeeds, so
+// failed operations don't use KSNs up.
+type DukptCounterTracker struct {
+	// Reject makes operations with replayed KSNs fail with ErrKsnReplayed,
+	// otherwise they are only notified
+	Reject bool
+	// ExhaustionWarning is how close to its last counter a device must be
+	// for exhaustion to be notified, zero disabling the warning
+	ExhaustionWarning uint64
+	Notify            func(DukptCounterEvent)
+}
+
+// check fails with ErrKsnReplayed when replays are rejected and the counter
+// of the KSN used by mech isn't greater than the recorded one
+func (t *DukptCounterTracker) check(db Database, mech cryptokit.Mechanism) error {
+	if t == nil || !t.Reject {
+		return nil
+	}
+
+	device, counter, _, ok, err := getTrackedCounter(mech)
+
+	if !ok || err != nil {
+		return err
+	}
+
+	previous, found, err := db.(CounterStore).FindCounter(hex.EncodeToString(device))
+
+	if err != nil {
+		return err
+	}
+
+	if found && counter <= previous {
+		t.notify(DukptCounterEvent{
+			Kind:     DukptCounterReplayed,
+			Device:   device,
+			Counter:  counter,
+			Previous: previous,
+		})
+
+		return ErrKsnReplayed
+	}
+
+	return nil
+}
+
+// record advances the counter of the KSN used by mech, if any, once the
+// operation succeeded. The KSN may have been used concurrently since it was
+// checked, in which case it is still rejected.
+func (t *DukptCounterTracker) record(db Database, mech cryptokit.Mechanism) error {
+	if t == nil {
+		return nil
+	}
+
+	device, counter, max, ok, err := getTrackedCounter(mech)
+
+	if !ok || err != nil {
+		return err
+	}
+
+	previous, advanced, err := db.(CounterStore).AdvanceCounter(hex.EncodeToString(device), counter)
+
+	if err != nil {
+		return err
+	}
+
+	if !advanced {
+		t.notify(DukptCounterEvent{
+			Kind:     DukptCounterReplayed,
+			Device:   device,
+			Counter:  counter,
+			Previous: previous,
+		})
+
+		if t.Reject {
+			return ErrKsnReplayed
+		}
+
+		return nil
+	}
+
+	if t.ExhaustionWarning != 0 && counter+t.ExhaustionWarning >= max {
+		t.notify(DukptCounterEvent{
+			Kind:     DukptCounterExhausting,
+			Device:   device,
+			Counter:  counter,
+			Previous: previous,
+		})
+	}
+
+	return nil
+}
+
+func (t *DukptCounterTracker) notify(event DukptCounterEvent) {
+	if t.Notify != nil {
+		t.Notify(event)
+	}
+}
+
+// getTrackedCounter returns the device and counter of the KSN used by mech
+// along with the device's last counter, if mech uses one. Response keys are
+// derived for KSNs already seen, so they aren't tracked.
+func getTrackedCounter(mech cryptokit.Mechanism) ([]byte, uint64, uint64, bool, error) {
+	d, ok := getDukptMechanism(mech)
+
+	if !ok || d.Variant == cryptokit.DukptMacResponseVariant || d.Variant == cryptokit.DukptDataResponseVariant {
+		return nil, 0, 0, false, nil
+	}
+
+	device, counter, max, err := getDukptCounter(d)
+
+	return device, counter, max, true, err
+}
+
+// getDukptCounter splits the KSN used by mech into its device and counter,
+// also returning the device's last counter
+func getDukptCounter(mech cryptokit.Dukpt) ([]byte, uint64, uint64, error) {
+	layout, err := getKsnLayout(mech.Algorithm, mech.Layout)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	device, err := getInitialKsn(mech.Algorithm, layout, mech.Ksn)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if mech.Algorithm == cryptokit.DukptAes {
+		counter := dukpt.DecodeAesKsn(mech.Ksn).Counter
+
+		return device[:dukpt.InitialKeyIdLength], uint64(counter), uint64(dukpt.MaxAesCounter), nil
+	}
+
+	counter := layout.Decode(mech.Ksn).Counter
+
+	return device, uint64(counter), layout.MaxCounter(), nil
+}
@@ -8,3 +8,20 @@ type Database interface {
 
 	Close() error
 }
+
+// CounterStore is implemented by databases able to keep the DUKPT
+// transaction counters a DukptCounterTracker relies on
+type CounterStore interface {
+	FindCounter(device string) (uint64, bool, error)
+	// AdvanceCounter records counter as the last one seen for device unless
+	// it isn't greater than the recorded one, returning the previous counter
+	AdvanceCounter(device string, counter uint64) (uint64, bool, error)
+}
+
+// RouteStore is implemented by databases able to keep the BDK routes set
+// with Provider.RouteBdk, key set identifiers being given in hex
+type RouteStore interface {
+	SaveRoute(ksi string, id string) error
+	FindRoute(ksi string) (string, bool, error)
+	RemoveRoute(ksi string) error
+}
@@ -0,0 +1,53 @@
+package soft
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/emv"
+)
+
+func deriveDiversifiedKey(mech cryptokit.KeyDiversification, key *Key, attributes cryptokit.KeyAttributes) ([]byte, error) {
+	if attributes.Extractable {
+		return nil, errors.New("Diversified keys can't be extractable")
+	}
+
+	switch mech.Method {
+	case cryptokit.EmvDiversification:
+		return deriveEmvKey(cryptokit.EmvIccMasterKey{
+			Pan:               mech.Pan,
+			PanSequenceNumber: mech.PanSequenceNumber,
+			Option:            mech.Option,
+		}, key, attributes)
+	case cryptokit.EcbDiversification:
+		return diversifyEcb(mech.Data, key, attributes)
+	}
+
+	return nil, errors.New("Unknown diversification method")
+}
+
+// diversifyEcb enciphers each block of data under the master key
+func diversifyEcb(data []byte, key *Key, attributes cryptokit.KeyAttributes) ([]byte, error) {
+	impl, err := getKeyImplementation(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if uint(len(data)) != attributes.Length || len(data)%impl.BlockSize() != 0 {
+		return nil, errors.New("Diversification data must have the derived key's length, a multiple of the block size")
+	}
+
+	result := make([]byte, len(data))
+
+	for i := 0; i < len(data); i += impl.BlockSize() {
+		impl.Encrypt(result[i:], data[i:])
+	}
+
+	switch attributes.Type {
+	case cryptokit.DesKey, cryptokit.TdesKey:
+		emv.AdjustParity(result)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,259 @@
+package soft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/dukpt"
+)
+
+var dukptVariants = map[cryptokit.DukptVariant]dukpt.Variant{
+	cryptokit.DukptPinVariant:          dukpt.PinVariant,
+	cryptokit.DukptMacRequestVariant:   dukpt.MacRequestVariant,
+	cryptokit.DukptMacResponseVariant:  dukpt.MacResponseVariant,
+	cryptokit.DukptDataRequestVariant:  dukpt.DataRequestVariant,
+	cryptokit.DukptDataResponseVariant: dukpt.DataResponseVariant,
+}
+
+// AES DUKPT has no variants, the request/response split maps onto the
+// generation/verification and encryption/decryption key usages
+var aesDukptUsages = map[cryptokit.DukptVariant]dukpt.KeyUsage{
+	cryptokit.DukptPinVariant:          dukpt.PinEncryptionUsage,
+	cryptokit.DukptMacRequestVariant:   dukpt.MacGenerationUsage,
+	cryptokit.DukptMacResponseVariant:  dukpt.MacVerificationUsage,
+	cryptokit.DukptDataRequestVariant:  dukpt.DataEncryptionUsage,
+	cryptokit.DukptDataResponseVariant: dukpt.DataDecryptionUsage,
+}
+
+// deriveDukptKey derives a working key from base, which is either a BDK or
+// an initial key carrying its initial KSN in its metadata
+func deriveDukptKey(mech cryptokit.Dukpt, base *Key, typ cryptokit.KeyType, length uint) ([]byte, error) {
+	layout, err := getKsnLayout(mech.Algorithm, mech.Layout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	initialKsn, err := getInitialKsn(mech.Algorithm, layout, mech.Ksn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	isIpek, err := checkIpekKsn(base, initialKsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch mech.Algorithm {
+	case cryptokit.DukptTdes:
+		variant, ok := dukptVariants[mech.Variant]
+
+		if !ok {
+			return nil, errors.New("Unknown DUKPT key variant")
+		}
+
+		if isIpek {
+			return layout.DeriveKeyFromIpek(base.data, mech.Ksn, variant)
+		}
+
+		return layout.DeriveKeyFromBdk(base.data, mech.Ksn, variant)
+	case cryptokit.DukptAes:
+		usage, ok := aesDukptUsages[mech.Variant]
+
+		if !ok {
+			return nil, errors.New("Unknown DUKPT key variant")
+		}
+
+		keyType, err := getAesDukptKeyType(typ, length)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if isIpek {
+			return dukpt.DeriveAesWorkingKeyFromInitialKey(base.data, mech.Ksn, usage, keyType)
+		}
+
+		return dukpt.DeriveAesWorkingKeyFromBdk(base.data, mech.Ksn, usage, keyType)
+	}
+
+	return nil, errors.New("Unknown DUKPT algorithm")
+}
+
+func deriveDukptIpek(mech cryptokit.DukptIpek, bdk *Key) ([]byte, []byte, error) {
+	layout, err := getKsnLayout(mech.Algorithm, mech.Layout)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initialKsn, err := getInitialKsn(mech.Algorithm, layout, mech.Ksn)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := bdk.metadata[cryptokit.DukptInitialKsnMetadata]; ok {
+		return nil, nil, errors.New("Initial keys can't be derived from initial keys")
+	}
+
+	var ipek []byte
+
+	switch mech.Algorithm {
+	case cryptokit.DukptTdes:
+		ipek, err = layout.DeriveIpekFromBdk(bdk.data, initialKsn)
+	case cryptokit.DukptAes:
+		ipek, err = dukpt.DeriveAesInitialKey(bdk.data, initialKsn[:dukpt.InitialKeyIdLength])
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ipek, initialKsn, nil
+}
+
+// checkIpekKsn reports whether key is an initial key, in which case it must
+// belong to the device identified by initialKsn
+func checkIpekKsn(key *Key, initialKsn []byte) (bool, error) {
+	encoded, ok := key.metadata[cryptokit.DukptInitialKsnMetadata]
+
+	if !ok {
+		return false, nil
+	}
+
+	keyKsn, err := hex.DecodeString(encoded)
+
+	if err != nil {
+		return true, err
+	}
+
+	if !bytes.Equal(keyKsn, initialKsn) {
+		return true, errors.New("KSN doesn't belong to the initial key's device")
+	}
+
+	return true, nil
+}
+
+// getKsnLayout returns the TDES KSN layout, AES DUKPT KSNs having a fixed
+// layout
+func getKsnLayout(algorithm cryptokit.DukptAlgorithm, layout cryptokit.DukptKsnLayout) (dukpt.KsnLayout, error) {
+	if layout == (cryptokit.DukptKsnLayout{}) {
+		return dukpt.DefaultKsnLayout, nil
+	}
+
+	if algorithm != cryptokit.DukptTdes {
+		return dukpt.KsnLayout{}, errors.New("KSN layouts only apply to TDES DUKPT")
+	}
+
+	result := dukpt.KsnLayout{
+		KsiLength:      layout.KsiLength,
+		DeviceIdLength: layout.DeviceIdLength,
+		CounterBits:    layout.CounterBits,
+	}
+
+	return result, result.Validate()
+}
+
+// getInitialKsn returns the KSN with its transaction counter cleared
+func getInitialKsn(algorithm cryptokit.DukptAlgorithm, layout dukpt.KsnLayout, ksn []byte) ([]byte, error) {
+	result := make([]byte, len(ksn))
+	copy(result, ksn)
+
+	switch algorithm {
+	case cryptokit.DukptTdes:
+		if len(ksn) != dukpt.KsnLength {
+			return nil, errors.New("Invalid KSN length")
+		}
+
+		result = layout.ClearCounter(ksn)
+	case cryptokit.DukptAes:
+		if len(ksn) != dukpt.AesKsnLength {
+			return nil, errors.New("Invalid KSN length")
+		}
+
+		copy(result[dukpt.InitialKeyIdLength:], []byte{0, 0, 0, 0})
+	default:
+		return nil, errors.New("Unknown DUKPT algorithm")
+	}
+
+	return result, nil
+}
+
+// getDukptDataMechanism rewrites mech as its mode enciphering with Dukpt, so
+// the data key is derived by getImplementation
+func getDukptDataMechanism(mech cryptokit.DukptData) (cryptokit.Mechanism, error) {
+	variant := cryptokit.DukptDataRequestVariant
+
+	if mech.Response {
+		variant = cryptokit.DukptDataResponseVariant
+	}
+
+	cipher := func(underlying cryptokit.Mechanism) cryptokit.Dukpt {
+		return cryptokit.Dukpt{
+			Ksn:        mech.Ksn,
+			Algorithm:  mech.Algorithm,
+			Layout:     mech.Layout,
+			Variant:    variant,
+			Underlying: underlying,
+		}
+	}
+
+	switch v := mech.Underlying.(type) {
+	case nil:
+		return cryptokit.Cbc{Underlying: cipher(nil)}, nil
+	case cryptokit.Cbc:
+		v.Underlying = cipher(v.Underlying)
+		return v, nil
+	case cryptokit.Ecb:
+		v.Underlying = cipher(v.Underlying)
+		return v, nil
+	case cryptokit.Gcm:
+		v.Underlying = cipher(v.Underlying)
+		return v, nil
+	}
+
+	return nil, errors.New("DUKPT data keys can only be used with block cipher modes")
+}
+
+// getDukptMechanism returns the Dukpt mechanism deriving the working key of
+// mech, if any
+func getDukptMechanism(mech cryptokit.Mechanism) (cryptokit.Dukpt, bool) {
+	switch v := mech.(type) {
+	case cryptokit.Dukpt:
+		return v, true
+	case cryptokit.DukptData:
+		underlying, err := getDukptDataMechanism(v)
+
+		if err != nil {
+			return cryptokit.Dukpt{}, false
+		}
+
+		return getDukptMechanism(underlying)
+	case cryptokit.BlockCipher:
+		return getDukptMechanism(v.BlockCipherUnderlying())
+	case cryptokit.Gcm:
+		return getDukptMechanism(v.Underlying)
+	case cryptokit.PinBlock:
+		return getDukptMechanism(v.Underlying)
+	}
+
+	return cryptokit.Dukpt{}, false
+}
+
+func getAesDukptKeyType(typ cryptokit.KeyType, length uint) (dukpt.KeyType, error) {
+	switch {
+	case typ == cryptokit.AesKey:
+		return dukpt.AesKeyTypeFromLength(int(length))
+	case typ == cryptokit.TdesKey && length == 16:
+		return dukpt.Tdes2Key, nil
+	case typ == cryptokit.TdesKey && length == 24:
+		return dukpt.Tdes3Key, nil
+	}
+
+	return 0, errors.New("Unsupported AES DUKPT working key type")
+}
@@ -0,0 +1,196 @@
+package dukpt
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+)
+
+// AES DUKPT as specified by ANSI X9.24-3-2017
+
+type KeyType uint16
+
+const (
+	Tdes2Key  KeyType = 0
+	Tdes3Key  KeyType = 1
+	Aes128Key KeyType = 2
+	Aes192Key KeyType = 3
+	Aes256Key KeyType = 4
+)
+
+type KeyUsage uint16
+
+const (
+	KeyEncryptionUsage   KeyUsage = 0x0002
+	PinEncryptionUsage   KeyUsage = 0x1000
+	MacGenerationUsage   KeyUsage = 0x2000
+	MacVerificationUsage KeyUsage = 0x2001
+	MacBothWaysUsage     KeyUsage = 0x2002
+	DataEncryptionUsage  KeyUsage = 0x3000
+	DataDecryptionUsage  KeyUsage = 0x3001
+	DataBothWaysUsage    KeyUsage = 0x3002
+	keyDerivationUsage   KeyUsage = 0x8000
+	initialKeyUsage      KeyUsage = 0x8001
+)
+
+const (
+	AesKsnLength       = 12
+	InitialKeyIdLength = 8
+	// Counters may have at most 16 one bits
+	MaxAesCounter uint32 = 0xFFFF0000
+)
+
+type AesKsn struct {
+	InitialKeyId []byte
+	Counter      uint32
+}
+
+func EncodeAesKsn(result []byte, ksn AesKsn) {
+	copy(result[:8], ksn.InitialKeyId)
+	binary.BigEndian.PutUint32(result[8:], ksn.Counter)
+}
+
+func DecodeAesKsn(ksn []byte) AesKsn {
+	result := AesKsn{
+		InitialKeyId: make([]byte, InitialKeyIdLength),
+		Counter:      binary.BigEndian.Uint32(ksn[8:12]),
+	}
+
+	copy(result.InitialKeyId, ksn[:8])
+
+	return result
+}
+
+// AesKeyTypeFromLength returns the AES key type of a key with the given
+// length in bytes
+func AesKeyTypeFromLength(length int) (KeyType, error) {
+	switch length {
+	case 16:
+		return Aes128Key, nil
+	case 24:
+		return Aes192Key, nil
+	case 32:
+		return Aes256Key, nil
+	}
+
+	return 0, errors.New("Invalid AES key length")
+}
+
+func DeriveAesInitialKey(bdk []byte, initialKeyId []byte) ([]byte, error) {
+	if len(initialKeyId) != InitialKeyIdLength {
+		return nil, errors.New("Invalid initial key ID")
+	}
+
+	keyType, err := AesKeyTypeFromLength(len(bdk))
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := createDerivationData(initialKeyUsage, keyType)
+	copy(data[8:], initialKeyId)
+
+	return deriveAesKey(bdk, keyType, data)
+}
+
+func DeriveAesWorkingKeyFromBdk(bdk []byte, ksn []byte, usage KeyUsage, workingKeyType KeyType) ([]byte, error) {
+	if len(ksn) != AesKsnLength {
+		return nil, errors.New("Invalid KSN length")
+	}
+
+	ik, err := DeriveAesInitialKey(bdk, ksn[:8])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DeriveAesWorkingKeyFromInitialKey(ik, ksn, usage, workingKeyType)
+}
+
+func DeriveAesWorkingKeyFromInitialKey(ik []byte, ksn []byte, usage KeyUsage, workingKeyType KeyType) ([]byte, error) {
+	if len(ksn) != AesKsnLength {
+		return nil, errors.New("Invalid KSN length")
+	}
+
+	keyType, err := AesKeyTypeFromLength(len(ik))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if keyLength(workingKeyType) == 0 || keyLength(workingKeyType) > len(ik) {
+		return nil, errors.New("Working key can't be stronger than the initial key")
+	}
+
+	counter := DecodeAesKsn(ksn).Counter
+	derivationKey := ik
+
+	var workingCounter uint32
+
+	for mask := uint32(0x80000000); mask != 0; mask >>= 1 {
+		if counter&mask == 0 {
+			continue
+		}
+
+		workingCounter |= mask
+
+		data := createDerivationData(keyDerivationUsage, keyType)
+		copy(data[8:12], ksn[4:8])
+		binary.BigEndian.PutUint32(data[12:16], workingCounter)
+
+		if derivationKey, err = deriveAesKey(derivationKey, keyType, data); err != nil {
+			return nil, err
+		}
+	}
+
+	data := createDerivationData(usage, workingKeyType)
+	copy(data[8:12], ksn[4:8])
+	binary.BigEndian.PutUint32(data[12:16], counter)
+
+	return deriveAesKey(derivationKey, workingKeyType, data)
+}
+
+func createDerivationData(usage KeyUsage, keyType KeyType) []byte {
+	data := make([]byte, 16)
+
+	data[0] = 0x01
+	data[1] = 0x01
+	binary.BigEndian.PutUint16(data[2:4], uint16(usage))
+	binary.BigEndian.PutUint16(data[4:6], uint16(keyType))
+	binary.BigEndian.PutUint16(data[6:8], uint16(keyLength(keyType)*8))
+
+	return data
+}
+
+// deriveAesKey implements the NIST SP 800-108 counter mode KDF using AES-ECB
+// as the PRF, the block counter being the second byte of the derivation data
+func deriveAesKey(derivationKey []byte, keyType KeyType, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(derivationKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	length := keyLength(keyType)
+	result := make([]byte, (length+15)/16*16)
+
+	for i := 0; i*16 < length; i++ {
+		data[1] = byte(i + 1)
+		block.Encrypt(result[i*16:(i+1)*16], data)
+	}
+
+	return result[:length], nil
+}
+
+func keyLength(keyType KeyType) int {
+	switch keyType {
+	case Tdes2Key, Aes128Key:
+		return 16
+	case Tdes3Key, Aes192Key:
+		return 24
+	case Aes256Key:
+		return 32
+	}
+
+	return 0
+}
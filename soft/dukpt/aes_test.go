@@ -0,0 +1,86 @@
+package dukpt
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAesBdk, _ = hex.DecodeString("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+var testInitialKeyId, _ = hex.DecodeString("1234567890123456")
+var testAesKsn, _ = hex.DecodeString("123456789012345600000001")
+
+func TestEncodeDecodeAesKsn(t *testing.T) {
+	encodedKsn := make([]byte, AesKsnLength)
+
+	ksn := DecodeAesKsn(testAesKsn)
+
+	assert.Equal(t, testInitialKeyId, ksn.InitialKeyId)
+	assert.Equal(t, uint32(1), ksn.Counter)
+
+	EncodeAesKsn(encodedKsn, ksn)
+
+	assert.Equal(t, testAesKsn, encodedKsn)
+}
+
+func TestAesInitialKeyDerivation(t *testing.T) {
+	ik, err := DeriveAesInitialKey(testAesBdk, testInitialKeyId)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1273671ea26ac29afa4d1084127652a1", hex.EncodeToString(ik), "Derived initial key should be correct")
+
+	_, err = DeriveAesInitialKey(testAesBdk[:10], testInitialKeyId)
+
+	assert.NotNil(t, err, "Invalid BDK lengths should be rejected")
+}
+
+func TestAesWorkingKeyDerivation(t *testing.T) {
+	expected := map[KeyUsage]string{
+		PinEncryptionUsage:  "af8cb133a78f8dc2d1359f18527593fb",
+		MacGenerationUsage:  "a2dc23de6fde0824a2bc321e08e4b8b7",
+		DataEncryptionUsage: "a35c412efd41fdb98b69797c02dcd08f",
+	}
+
+	for usage, key := range expected {
+		derived, err := DeriveAesWorkingKeyFromBdk(testAesBdk, testAesKsn, usage, Aes128Key)
+
+		assert.Nil(t, err)
+		assert.Equal(t, key, hex.EncodeToString(derived), "Derived working key should be correct")
+	}
+
+	ik, _ := DeriveAesInitialKey(testAesBdk, testInitialKeyId)
+
+	fromIk, err := DeriveAesWorkingKeyFromInitialKey(ik, testAesKsn, PinEncryptionUsage, Aes128Key)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected[PinEncryptionUsage], hex.EncodeToString(fromIk))
+
+	tdes, err := DeriveAesWorkingKeyFromBdk(testAesBdk, testAesKsn, PinEncryptionUsage, Tdes2Key)
+
+	assert.Nil(t, err)
+	assert.Len(t, tdes, 16)
+
+	_, err = DeriveAesWorkingKeyFromBdk(testAesBdk, testAesKsn, PinEncryptionUsage, Aes256Key)
+
+	assert.NotNil(t, err, "Working keys stronger than the BDK should be rejected")
+
+	_, err = DeriveAesWorkingKeyFromBdk(testAesBdk, testKsn, PinEncryptionUsage, Aes128Key)
+
+	assert.NotNil(t, err, "TDES KSNs should be rejected")
+}
+
+func TestAes256Derivation(t *testing.T) {
+	bdk := make([]byte, 32)
+	copy(bdk, testAesBdk)
+
+	derived, err := DeriveAesWorkingKeyFromBdk(bdk, testAesKsn, DataEncryptionUsage, Aes256Key)
+
+	assert.Nil(t, err)
+	assert.Len(t, derived, 32)
+
+	derived128, err := DeriveAesWorkingKeyFromBdk(bdk, testAesKsn, DataEncryptionUsage, Aes128Key)
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, derived[:16], derived128, "Key length should be bound to the derivation")
+}
@@ -0,0 +1,184 @@
+package dukpt
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit/soft/mac"
+	"github.com/pagarme/cryptokit/soft/pin"
+	"github.com/pagarme/cryptokit/soft/tdes"
+)
+
+const (
+	FutureKeyRegisters = 21
+	MaxOneBits         = 10
+)
+
+var ErrDeviceExhausted = errors.New("Transaction counter exhausted")
+
+// Device simulates the originating side of ANSI X9.24-1 TDES DUKPT, keeping
+// one future key register per transaction counter bit. The key for the
+// current transaction is held in the register of the counter's rightmost one
+// bit.
+type Device struct {
+	layout    KsnLayout
+	ksn       []byte
+	counter   uint64
+	registers [FutureKeyRegisters][]byte
+}
+
+// NewDevice loads the initial key and KSN into a new device, the counter
+// bits of the KSN being ignored.
+func NewDevice(ipek []byte, ksn []byte) (*Device, error) {
+	return NewDeviceWithLayout(ipek, ksn, DefaultKsnLayout)
+}
+
+func NewDeviceWithLayout(ipek []byte, ksn []byte, layout KsnLayout) (*Device, error) {
+	if len(ipek) != 16 {
+		return nil, errors.New("Invalid IPEK length")
+	}
+
+	if err := layout.check(ksn); err != nil {
+		return nil, err
+	}
+
+	d := &Device{
+		layout: layout,
+		ksn:    layout.ClearCounter(ksn),
+	}
+
+	if err := d.generateFutureKeys(ipek, uint64(1)<<uint(layout.CounterBits-1)); err != nil {
+		return nil, err
+	}
+
+	d.counter = 1
+
+	return d, nil
+}
+
+// Ksn returns the KSN of the current transaction
+func (d *Device) Ksn() []byte {
+	ksn := make([]byte, KsnLength)
+
+	copy(ksn, d.ksn)
+	d.layout.setCounter(ksn, d.counter)
+
+	return ksn
+}
+
+func (d *Device) Counter() int {
+	return int(d.counter)
+}
+
+// CurrentKey returns the working key of the given variant for the current
+// transaction
+func (d *Device) CurrentKey(variant Variant) ([]byte, error) {
+	if d.counter > d.layout.counterMask() {
+		return nil, ErrDeviceExhausted
+	}
+
+	key := make([]byte, 16)
+	copy(key, d.registers[rightmostBit(d.counter)])
+
+	return applyVariant(key, variant)
+}
+
+// Advance ends the current transaction, filling the future key registers
+// below the current one and erasing the current key. Counters with more
+// than MaxOneBits one bits are skipped.
+func (d *Device) Advance() error {
+	if d.counter > d.layout.counterMask() {
+		return ErrDeviceExhausted
+	}
+
+	current := rightmostBit(d.counter)
+	shiftReg := uint64(1) << uint(current)
+
+	if countOneBits(d.counter) < MaxOneBits {
+		if err := d.generateFutureKeys(d.registers[current], shiftReg>>1); err != nil {
+			return err
+		}
+
+		d.counter++
+	} else {
+		d.counter += shiftReg
+	}
+
+	d.registers[current] = nil
+
+	if d.counter > d.layout.counterMask() {
+		return ErrDeviceExhausted
+	}
+
+	return nil
+}
+
+// EncryptPinBlock enciphers an ISO 9564 PIN block under the current PIN
+// encryption key
+func (d *Device) EncryptPinBlock(format int, p, pan string) ([]byte, error) {
+	key, err := d.CurrentKey(PinVariant)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := tdes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pin.Encrypt(block, format, p, pan)
+}
+
+// Mac computes the ANSI X9.19 retail MAC of data under the current MAC
+// request key, data being padded with zeros
+func (d *Device) Mac(data []byte) ([]byte, error) {
+	key, err := d.CurrentKey(MacRequestVariant)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mac.RetailMac(key, data, false)
+}
+
+// generateFutureKeys fills the registers of every bit from shiftReg down to
+// the least significant one using key and the current counter
+func (d *Device) generateFutureKeys(key []byte, shiftReg uint64) error {
+	ksn := make([]byte, KsnLength)
+
+	for ; shiftReg != 0; shiftReg >>= 1 {
+		register := make([]byte, 16)
+
+		copy(ksn, d.ksn)
+		d.layout.setCounter(ksn, d.counter|shiftReg)
+
+		if err := keygen(register, key, ksn[2:]); err != nil {
+			return err
+		}
+
+		d.registers[rightmostBit(shiftReg)] = register
+	}
+
+	return nil
+}
+
+func rightmostBit(v uint64) int {
+	for i := 0; i < 64; i++ {
+		if v&(1<<uint(i)) != 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func countOneBits(v uint64) int {
+	count := 0
+
+	for ; v != 0; v &= v - 1 {
+		count++
+	}
+
+	return count
+}
@@ -0,0 +1,99 @@
+package dukpt
+
+import (
+	"testing"
+
+	"github.com/pagarme/cryptokit/soft/mac"
+	"github.com/pagarme/cryptokit/soft/pin"
+	"github.com/pagarme/cryptokit/soft/tdes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceMatchesHost(t *testing.T) {
+	d, err := NewDevice(testIpek, testKsn)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, d.Counter())
+
+	for i := 0; i < 4096; i++ {
+		ksn := d.Ksn()
+		counter := DecodeKsn(ksn).Counter
+
+		assert.True(t, countOneBits(uint64(counter)) <= MaxOneBits, "Counters with too many one bits should be skipped")
+
+		expected, _ := DeriveKeyFromIpek(testIpek, ksn, PinVariant)
+		key, err := d.CurrentKey(PinVariant)
+
+		assert.Nil(t, err)
+
+		if !assert.Equal(t, expected, key, "Device key should match host derivation for counter %d", counter) {
+			return
+		}
+
+		assert.Nil(t, d.Advance())
+	}
+
+	// The 13 counters below 4096 with more than ten one bits are skipped
+	assert.Equal(t, 4096+1+13, d.Counter())
+}
+
+func TestDeviceKnownKey(t *testing.T) {
+	d, err := NewDevice(testIpek, testKsn)
+
+	assert.Nil(t, err)
+
+	for d.Counter() < 8 {
+		assert.Nil(t, d.Advance())
+	}
+
+	assert.Equal(t, testKsn, d.Ksn())
+
+	key, err := d.CurrentKey(PinVariant)
+
+	assert.Nil(t, err)
+	assert.Equal(t, testPek, key)
+}
+
+func TestDevicePinBlockAndMac(t *testing.T) {
+	d, _ := NewDevice(testIpek, testKsn)
+
+	block, err := d.EncryptPinBlock(0, "1234", "4111111111111111")
+
+	assert.Nil(t, err)
+
+	pek, _ := DerivePekFromIpek(testIpek, d.Ksn())
+	pekBlock, _ := tdes.NewCipher(pek)
+	clearPin, err := pin.Decrypt(pekBlock, 0, block, "4111111111111111")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1234", clearPin)
+
+	result, err := d.Mac([]byte("4111111111111111"))
+
+	assert.Nil(t, err)
+
+	key, _ := d.CurrentKey(MacRequestVariant)
+	expected, _ := mac.RetailMac(key, []byte("4111111111111111"), false)
+
+	assert.Equal(t, expected, result)
+}
+
+func TestDeviceExhaustion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping device exhaustion in short mode")
+	}
+
+	d, _ := NewDevice(testIpek, testKsn)
+
+	var err error
+
+	for err == nil {
+		err = d.Advance()
+	}
+
+	assert.Equal(t, ErrDeviceExhausted, err)
+
+	_, err = d.CurrentKey(PinVariant)
+
+	assert.Equal(t, ErrDeviceExhausted, err)
+}
@@ -1,10 +1,11 @@
 package dukpt
 
 import (
-	"bytes"
 	"crypto/des"
 	"encoding/binary"
-	"unsafe"
+	"errors"
+
+	"github.com/pagarme/cryptokit/soft/tdes"
 )
 
 type Ksn struct {
@@ -13,53 +14,35 @@ type Ksn struct {
 	Counter int
 }
 
+// Variant selects which working key is derived from the current future key
+type Variant int
+
+const (
+	PinVariant Variant = iota
+	MacRequestVariant
+	MacResponseVariant
+	DataRequestVariant
+	DataResponseVariant
+)
+
 var (
-	REG3_MASK      uint64 = 0x1FFFFF
-	SHIFT_REG_MASK uint64 = 0x100000
-	REG8_MASK      uint64 = 0xFFFFFFFFFFE00000
-	KEY_MASK              = []byte{0xC0, 0xC0, 0xC0, 0xC0, 0x00, 0x00, 0x00, 0x00, 0xC0, 0xC0, 0xC0, 0xC0, 0x00, 0x00, 0x00, 0x00}
-	PEK_MASK              = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF}
+	REG3_MASK          uint64 = 0x1FFFFF
+	SHIFT_REG_MASK     uint64 = 0x100000
+	REG8_MASK          uint64 = 0xFFFFFFFFFFE00000
+	KEY_MASK                  = []byte{0xC0, 0xC0, 0xC0, 0xC0, 0x00, 0x00, 0x00, 0x00, 0xC0, 0xC0, 0xC0, 0xC0, 0x00, 0x00, 0x00, 0x00}
+	PEK_MASK                  = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF}
+	MAC_REQUEST_MASK          = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00}
+	MAC_RESPONSE_MASK         = []byte{0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00}
+	DATA_REQUEST_MASK         = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00}
+	DATA_RESPONSE_MASK        = []byte{0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00}
 )
 
-// This isn't official as there is no specification on how to build the KSI
-// Aside from TC's 21 bits, the remaining 59 bits are opaque
-// Here we assume the 10-5-5 scheme
 func EncodeKsn(result []byte, ksn Ksn) {
-	result[0] = ksn.Ksi[0]
-	result[1] = ksn.Ksi[1]
-	result[2] = ksn.Ksi[2]
-	result[3] = ksn.Ksi[3]
-	result[4] = ksn.Ksi[4]
-
-	result[5] = ksn.Trsm[0]
-	result[6] = ksn.Trsm[1]
-	result[7] = ksn.Trsm[2] & 0xE0
-
-	result[7] |= byte((ksn.Counter >> 16) & 0x1F)
-	result[8] = byte((ksn.Counter >> 8) & 0xFF)
-	result[9] = byte(ksn.Counter & 0xFF)
+	DefaultKsnLayout.Encode(result, ksn)
 }
 
-func DecodeKsn(ksn []byte) (result Ksn) {
-	result = Ksn{
-		Ksi:     make([]byte, 5),
-		Trsm:    make([]byte, 3),
-		Counter: 0,
-	}
-
-	result.Ksi[0] = ksn[0]
-	result.Ksi[1] = ksn[1]
-	result.Ksi[2] = ksn[2]
-	result.Ksi[3] = ksn[3]
-	result.Ksi[4] = ksn[4]
-
-	result.Trsm[0] = ksn[5]
-	result.Trsm[1] = ksn[6]
-	result.Trsm[2] = ksn[7] & 0xE0
-
-	result.Counter = int((uint64(ksn[9]) | (uint64(ksn[8]) << 8) | (uint64(ksn[7]&0x1F) << 16)))
-
-	return
+func DecodeKsn(ksn []byte) Ksn {
+	return DefaultKsnLayout.Decode(ksn)
 }
 
 func CalculateKcv(key []byte) ([]byte, error) {
@@ -73,17 +56,39 @@ func CalculateKcv(key []byte) ([]byte, error) {
 }
 
 func ExtractKsnWithoutCounter(ksn, cleared []byte) {
-	// Clear the KSN counter
-	copy(cleared, ksn[:8])
-	cleared[7] &= 0xE0
+	DefaultKsnLayout.ExtractKsnWithoutCounter(ksn, cleared)
 }
 
 func DeriveIpekFromBdk(bdk []byte, ksn []byte) ([]byte, error) {
+	return DefaultKsnLayout.DeriveIpekFromBdk(bdk, ksn)
+}
+
+func DerivePekFromIpek(ipek []byte, ksn []byte) ([]byte, error) {
+	return DefaultKsnLayout.DeriveKeyFromIpek(ipek, ksn, PinVariant)
+}
+
+func DerivePekFromBdk(bdk []byte, ksn []byte) ([]byte, error) {
+	return DefaultKsnLayout.DeriveKeyFromBdk(bdk, ksn, PinVariant)
+}
+
+func DeriveKeyFromIpek(ipek []byte, ksn []byte, variant Variant) ([]byte, error) {
+	return DefaultKsnLayout.DeriveKeyFromIpek(ipek, ksn, variant)
+}
+
+func DeriveKeyFromBdk(bdk []byte, ksn []byte, variant Variant) ([]byte, error) {
+	return DefaultKsnLayout.DeriveKeyFromBdk(bdk, ksn, variant)
+}
+
+func (l KsnLayout) DeriveIpekFromBdk(bdk []byte, ksn []byte) ([]byte, error) {
+	if err := l.check(ksn); err != nil {
+		return nil, err
+	}
+
 	cleared := make([]byte, 8)
 	xored := make([]byte, 16)
 	ipek := make([]byte, 16)
 
-	ExtractKsnWithoutCounter(ksn, cleared)
+	l.ExtractKsnWithoutCounter(ksn, cleared)
 
 	// Xor the BDK for the second key
 	xorWords(xored, bdk, KEY_MASK)
@@ -99,48 +104,91 @@ func DeriveIpekFromBdk(bdk []byte, ksn []byte) ([]byte, error) {
 	return ipek, nil
 }
 
-func DerivePekFromIpek(ipek []byte, ksn []byte) ([]byte, error) {
+func (l KsnLayout) DeriveKeyFromIpek(ipek []byte, ksn []byte, variant Variant) ([]byte, error) {
+	if err := l.check(ksn); err != nil {
+		return nil, err
+	}
+
 	key := make([]byte, 16)
 
-	if err := deriveKey(key, ipek, ksn); err != nil {
+	if err := l.deriveKey(key, ipek, ksn); err != nil {
 		return nil, err
 	}
 
-	xorWords(key, key, PEK_MASK)
-
-	return key, nil
+	return applyVariant(key, variant)
 }
 
-func DerivePekFromBdk(bdk []byte, ksn []byte) ([]byte, error) {
-	ipek, err := DeriveIpekFromBdk(bdk, ksn)
+func (l KsnLayout) DeriveKeyFromBdk(bdk []byte, ksn []byte, variant Variant) ([]byte, error) {
+	ipek, err := l.DeriveIpekFromBdk(bdk, ksn)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return DerivePekFromIpek(ipek, ksn)
+	return l.DeriveKeyFromIpek(ipek, ksn, variant)
+}
+
+func (l KsnLayout) check(ksn []byte) error {
+	if len(ksn) != KsnLength {
+		return errors.New("Invalid KSN length")
+	}
+
+	return l.Validate()
 }
 
-func deriveKey(dst, ipek, ksn []byte) error {
-	var shiftReg uint64
+func applyVariant(key []byte, variant Variant) ([]byte, error) {
+	switch variant {
+	case PinVariant:
+		xorWords(key, key, PEK_MASK)
+	case MacRequestVariant:
+		xorWords(key, key, MAC_REQUEST_MASK)
+	case MacResponseVariant:
+		xorWords(key, key, MAC_RESPONSE_MASK)
+	case DataRequestVariant:
+		xorWords(key, key, DATA_REQUEST_MASK)
+
+		return dataKeyOneWay(key)
+	case DataResponseVariant:
+		xorWords(key, key, DATA_RESPONSE_MASK)
+
+		return dataKeyOneWay(key)
+	default:
+		return nil, errors.New("Unknown key variant")
+	}
+
+	return key, nil
+}
+
+// Data encryption keys are each half of the variant key encrypted under
+// the variant key itself
+func dataKeyOneWay(variantKey []byte) ([]byte, error) {
+	key := make([]byte, 16)
+
+	if err := tdesEncrypt(key[0:8], variantKey[0:8], variantKey); err != nil {
+		return nil, err
+	}
+
+	if err := tdesEncrypt(key[8:16], variantKey[8:16], variantKey); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
 
+func (l KsnLayout) deriveKey(dst, ipek, ksn []byte) error {
 	copy(dst, ipek)
 
 	reg8 := make([]byte, 8)
-	copy(reg8, ksn[2:])
-	reg8[7] = 0
-	reg8[6] = 0
-	reg8[5] &= 0xE0
+	base := decodeInt64(ksn[2:]) &^ l.counterMask()
+	counter := l.counter(ksn)
 
-	counter := decodeInt64(ksn[2:]) & REG3_MASK
+	var working uint64
 
-	for shiftReg = SHIFT_REG_MASK; shiftReg != 0; shiftReg >>= 1 {
+	for shiftReg := uint64(1) << uint(l.CounterBits-1); shiftReg != 0; shiftReg >>= 1 {
 		if shiftReg&counter != 0 {
-			ptr := *(*[8]byte)(unsafe.Pointer(&shiftReg))
+			working |= shiftReg
 
-			reg8[5] |= ptr[2]
-			reg8[6] |= ptr[1]
-			reg8[7] |= ptr[0]
+			putInt64(reg8, base|working)
 
 			if err := keygen(dst, dst, reg8); err != nil {
 				return err
@@ -188,22 +236,8 @@ func encryptRegister(dst, curkey, reg8 []byte) error {
 	return nil
 }
 
-func buildTdesKey(key []byte) []byte {
-	var finalKey []byte
-
-	if len(key) == 24 {
-		finalKey = key
-	} else if len(key) == 16 {
-		finalKey = make([]byte, 24)
-		copy(finalKey, key)
-		copy(finalKey[16:], key[:8])
-	}
-
-	return finalKey
-}
-
 func tdesEncrypt(dst, data, key []byte) error {
-	block, err := des.NewTripleDESCipher(buildTdesKey(key))
+	block, err := tdes.NewCipher(key)
 
 	if err != nil {
 		return err
@@ -226,15 +260,10 @@ func desEncrypt(dst, data, key []byte) error {
 	return nil
 }
 
-func decodeInt64(b []byte) (result uint64) {
-	buffer := bytes.NewBuffer(b)
-	binary.Read(buffer, binary.BigEndian, &result)
-	return
+func decodeInt64(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
 }
 
-func encodeInt64(b uint64) (result []byte) {
-	result = make([]byte, 8)
-	buffer := bytes.NewBuffer(result)
-	binary.Write(buffer, binary.BigEndian, b)
-	return
+func putInt64(b []byte, v uint64) {
+	binary.BigEndian.PutUint64(b, v)
 }
@@ -2,7 +2,8 @@ package dukpt
 
 import (
 	"crypto/cipher"
-	"crypto/des"
+	"encoding/hex"
+	"github.com/pagarme/cryptokit/soft/tdes"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -48,8 +49,8 @@ func TestDecryption(t *testing.T) {
 	assert.NotNil(t, pek)
 	assert.Equal(t, testPek, pek, "Derived PEK should be correct")
 
-	tdes, _ := des.NewTripleDESCipher(buildTdesKey(pek))
-	cbc := cipher.NewCBCDecrypter(tdes, make([]byte, 8))
+	block, _ := tdes.NewCipher(pek)
+	cbc := cipher.NewCBCDecrypter(block, make([]byte, 8))
 
 	result := make([]byte, len(testCiphertext))
 
@@ -57,3 +58,24 @@ func TestDecryption(t *testing.T) {
 
 	assert.Equal(t, "%B5452300551227189^HOGAN/PAUL      ^08043210000000725000000?\x00\x00\x00\x00", string(result))
 }
+
+func TestKeyVariants(t *testing.T) {
+	ksn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x01}
+
+	expected := map[Variant]string{
+		PinVariant:         "042666b49184cf5c68de9628d0397b36",
+		MacRequestVariant:  "042666b4918430a368de9628d03984c9",
+		DataRequestVariant: "448d3f076d8304036a55a3d7e0055a78",
+	}
+
+	for variant, key := range expected {
+		derived, err := DeriveKeyFromBdk(testBdk, ksn, variant)
+
+		assert.Nil(t, err)
+		assert.Equal(t, key, hex.EncodeToString(derived), "Derived key should be correct")
+	}
+
+	_, err := DeriveKeyFromBdk(testBdk, ksn, Variant(42))
+
+	assert.NotNil(t, err, "Unknown variants should be rejected")
+}
@@ -0,0 +1,165 @@
+package dukpt
+
+import (
+	"errors"
+)
+
+const KsnLength = 10
+
+// KsnLayout describes how the 80 bits of a TDES KSN are split. KsiLength and
+// DeviceIdLength are given in hex digits and the transaction counter takes
+// the rightmost CounterBits bits, overlapping the device ID if needed.
+type KsnLayout struct {
+	KsiLength      int
+	DeviceIdLength int
+	CounterBits    int
+}
+
+// This isn't official as there is no specification on how to build the KSI
+// Aside from TC's 21 bits, the remaining 59 bits are opaque
+// By default we assume the 10-5-5 scheme
+var DefaultKsnLayout = KsnLayout{
+	KsiLength:      10,
+	DeviceIdLength: 5,
+	CounterBits:    21,
+}
+
+func (l KsnLayout) Validate() error {
+	if l.CounterBits < 1 || l.CounterBits > FutureKeyRegisters {
+		return errors.New("The transaction counter must have between 1 and 21 bits")
+	}
+
+	if l.KsiLength < 0 || l.DeviceIdLength < 0 {
+		return errors.New("Invalid KSN layout")
+	}
+
+	if l.KsiLength*4+l.CounterBits > KsnLength*8 {
+		return errors.New("The transaction counter can't overlap the KSI")
+	}
+
+	if (l.KsiLength+l.DeviceIdLength)*4+l.CounterBits < KsnLength*8 {
+		return errors.New("The KSN layout must cover the whole KSN")
+	}
+
+	return nil
+}
+
+// Encode builds the KSN from its fields, which hold the KSN bytes they span
+// with the bits of other fields cleared
+func (l KsnLayout) Encode(result []byte, ksn Ksn) {
+	for i := range result[:KsnLength] {
+		result[i] = 0
+	}
+
+	ksiEnd, deviceEnd := l.bounds()
+
+	orBits(result, ksn.Ksi, 0, ksiEnd)
+	orBits(result, ksn.Trsm, ksiEnd, deviceEnd)
+
+	l.setCounter(result, uint64(ksn.Counter))
+}
+
+func (l KsnLayout) Decode(ksn []byte) Ksn {
+	ksiEnd, deviceEnd := l.bounds()
+
+	return Ksn{
+		Ksi:     extractBits(ksn, 0, ksiEnd),
+		Trsm:    extractBits(ksn, ksiEnd, deviceEnd),
+		Counter: int(l.counter(ksn)),
+	}
+}
+
+// ClearCounter returns a copy of the KSN with its transaction counter cleared
+func (l KsnLayout) ClearCounter(ksn []byte) []byte {
+	result := make([]byte, KsnLength)
+
+	copy(result, ksn)
+	l.setCounter(result, 0)
+
+	return result
+}
+
+// ExtractKsnWithoutCounter copies the leftmost 64 bits of the KSN with the
+// transaction counter cleared into cleared
+func (l KsnLayout) ExtractKsnWithoutCounter(ksn, cleared []byte) {
+	copy(cleared, l.ClearCounter(ksn)[:8])
+}
+
+// MaxCounter returns the last transaction counter an originating device can
+// reach, the one with the MaxOneBits leftmost counter bits set
+func (l KsnLayout) MaxCounter() uint64 {
+	ones := l.CounterBits
+
+	if ones > MaxOneBits {
+		ones = MaxOneBits
+	}
+
+	return l.counterMask() &^ (uint64(1)<<uint(l.CounterBits-ones) - 1)
+}
+
+func (l KsnLayout) bounds() (int, int) {
+	ksiEnd := l.KsiLength * 4
+	deviceEnd := (l.KsiLength + l.DeviceIdLength) * 4
+
+	if deviceEnd > KsnLength*8-l.CounterBits {
+		deviceEnd = KsnLength*8 - l.CounterBits
+	}
+
+	return ksiEnd, deviceEnd
+}
+
+func (l KsnLayout) counterMask() uint64 {
+	return (uint64(1) << uint(l.CounterBits)) - 1
+}
+
+func (l KsnLayout) counter(ksn []byte) uint64 {
+	return decodeInt64(ksn[2:KsnLength]) & l.counterMask()
+}
+
+func (l KsnLayout) setCounter(ksn []byte, counter uint64) {
+	mask := l.counterMask()
+	reg8 := decodeInt64(ksn[2:KsnLength])&^mask | counter&mask
+
+	putInt64(ksn[2:KsnLength], reg8)
+}
+
+// extractBits returns the bytes of src spanning bits [from, to) with every
+// other bit cleared
+func extractBits(src []byte, from, to int) []byte {
+	if to <= from {
+		return []byte{}
+	}
+
+	result := make([]byte, (to+7)/8-from/8)
+
+	for i := range result {
+		result[i] = src[from/8+i] & bitMask(from/8+i, from, to)
+	}
+
+	return result
+}
+
+func orBits(dst []byte, src []byte, from, to int) {
+	if to <= from {
+		return
+	}
+
+	for i := 0; i < (to+7)/8-from/8 && i < len(src); i++ {
+		dst[from/8+i] |= src[i] & bitMask(from/8+i, from, to)
+	}
+}
+
+// bitMask returns the mask of the bits of byte index that lie in [from, to)
+func bitMask(index, from, to int) byte {
+	var mask byte
+
+	for bit := 0; bit < 8; bit++ {
+		pos := index*8 + bit
+
+		if pos >= from && pos < to {
+			mask |= 0x80 >> uint(bit)
+		}
+	}
+
+	return mask
+}
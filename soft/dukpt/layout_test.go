@@ -0,0 +1,71 @@
+package dukpt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testLayout = KsnLayout{
+	KsiLength:      9,
+	DeviceIdLength: 6,
+	CounterBits:    20,
+}
+
+func TestLayoutEncodeDecode(t *testing.T) {
+	ksn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x08}
+	encoded := make([]byte, KsnLength)
+
+	decoded := testLayout.Decode(ksn)
+
+	assert.Equal(t, []byte{0xFF, 0xFF, 0x98, 0x76, 0x50}, decoded.Ksi)
+	assert.Equal(t, []byte{0x04, 0x32, 0x10, 0xE0}, decoded.Trsm)
+	assert.Equal(t, 8, decoded.Counter)
+
+	testLayout.Encode(encoded, decoded)
+
+	assert.Equal(t, ksn, encoded)
+	assert.Equal(t, []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x00}, testLayout.ClearCounter(ksn))
+}
+
+func TestLayoutValidation(t *testing.T) {
+	assert.Nil(t, DefaultKsnLayout.Validate())
+	assert.Nil(t, testLayout.Validate())
+
+	assert.NotNil(t, KsnLayout{10, 5, 22}.Validate())
+	assert.NotNil(t, KsnLayout{10, 5, 0}.Validate())
+	assert.NotNil(t, KsnLayout{16, 0, 20}.Validate())
+	assert.NotNil(t, KsnLayout{8, 4, 21}.Validate())
+
+	_, err := KsnLayout{10, 5, 22}.DeriveIpekFromBdk(testBdk, testKsn)
+
+	assert.NotNil(t, err)
+}
+
+func TestLayoutDerivation(t *testing.T) {
+	ipek, err := testLayout.DeriveIpekFromBdk(testBdk, testKsn)
+
+	assert.Nil(t, err)
+	assert.Equal(t, testIpek, ipek, "The IPEK only depends on the cleared KSN")
+
+	device, err := NewDeviceWithLayout(ipek, testKsn, testLayout)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		expected, err := testLayout.DeriveKeyFromIpek(ipek, device.Ksn(), PinVariant)
+
+		assert.Nil(t, err)
+
+		key, err := device.CurrentKey(PinVariant)
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, key)
+		assert.Nil(t, device.Advance())
+	}
+}
+
+func TestLayoutMaxCounter(t *testing.T) {
+	assert.Equal(t, uint64(0x1FF800), DefaultKsnLayout.MaxCounter())
+	assert.Equal(t, uint64(0xFFC00), testLayout.MaxCounter())
+	assert.Equal(t, uint64(0xFF), KsnLayout{15, 5, 8}.MaxCounter())
+}
@@ -0,0 +1,92 @@
+package soft
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/emv"
+	"github.com/pagarme/cryptokit/soft/pin"
+)
+
+func deriveEmvKey(mech cryptokit.Mechanism, key *Key, attributes cryptokit.KeyAttributes) ([]byte, error) {
+	if attributes.Type != cryptokit.TdesKey || attributes.Length != 16 {
+		return nil, emv.ErrInvalidKey
+	}
+
+	switch v := mech.(type) {
+	case cryptokit.EmvIccMasterKey:
+		if attributes.Extractable {
+			return nil, errors.New("ICC master keys can't be extractable")
+		}
+
+		return emv.DeriveIccMasterKey(key.data, v.Pan, v.PanSequenceNumber, emv.Option(v.Option))
+	case cryptokit.EmvSessionKey:
+		r := v.Ac
+
+		if r == nil {
+			var err error
+
+			if r, err = emv.AtcDiversification(v.Atc); err != nil {
+				return nil, err
+			}
+		}
+
+		return emv.DeriveSessionKey(key.data, r)
+	}
+
+	return nil, errors.New("Unsupported mechanism")
+}
+
+func processEmvArpc(mech cryptokit.EmvArpc, key cryptokit.Key) ([]byte, error) {
+	switch mech.Method {
+	case 0, 1:
+		return emv.ArpcMethod1(key.(*Key).data, mech.Arqc, mech.Arc)
+	case 2:
+		return emv.ArpcMethod2(key.(*Key).data, mech.Arqc, mech.Csu, mech.ProprietaryData)
+	}
+
+	return nil, errors.New("Unknown ARPC method")
+}
+
+func processEmvSecureMessagingMac(mech cryptokit.EmvSecureMessagingMac, key cryptokit.Key, in []byte) ([]byte, error) {
+	length := mech.Length
+
+	if length == 0 {
+		length = 8
+	}
+
+	return emv.SecureMessagingMac(key.(*Key).data, in, length)
+}
+
+func processEmvSecureMessaging(mech cryptokit.EmvSecureMessagingEncryption, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
+	if encrypt {
+		return emv.EncryptSecureMessaging(key.(*Key).data, in, mech.Ecb)
+	}
+
+	return emv.DecryptSecureMessaging(key.(*Key).data, in, mech.Ecb)
+}
+
+func translateEmvPinChange(inMech cryptokit.PinBlock, inKey cryptokit.Key, in []byte, outMech cryptokit.EmvPinChange, outKey cryptokit.Key) ([]byte, error) {
+	if err := checkCapability(outMech, outKey, cryptokit.Encrypt); err != nil {
+		return nil, err
+	}
+
+	// The PIN could be decrypted in clear otherwise
+	if outKey.Attributes().Capabilities&cryptokit.Decrypt != 0 {
+		return nil, errors.New("PIN change keys can't be allowed to decrypt")
+	}
+
+	p, err := decryptPinBlock(inMech, inKey, in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := pin.EncodeFormat2(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return emv.EncryptSecureMessaging(outKey.(*Key).data, block, outMech.Ecb)
+}
@@ -0,0 +1,277 @@
+package emv
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/pagarme/cryptokit/soft/mac"
+	"github.com/pagarme/cryptokit/soft/tdes"
+)
+
+type Option int
+
+const (
+	OptionA Option = iota
+	OptionB
+)
+
+type Padding int
+
+const (
+	// ISO 9797-1 padding method 2, a 0x80 byte followed by zeros
+	PaddingMethod2 Padding = iota
+	// ISO 9797-1 padding method 1, zeros only
+	PaddingMethod1
+)
+
+var (
+	ErrInvalidPan  = errors.New("Invalid PAN")
+	ErrInvalidPsn  = errors.New("Invalid PAN sequence number")
+	ErrInvalidKey  = errors.New("EMV keys must be double length DES keys")
+	ErrInvalidArqc = errors.New("Invalid ARQC")
+)
+
+// DeriveIccMasterKey derives the ICC master key of a card from the issuer
+// master key as specified by EMV Book 2 A1.4. Option B only applies to PANs
+// longer than 16 digits, option A being used for shorter ones.
+func DeriveIccMasterKey(imk []byte, pan, psn string, option Option) ([]byte, error) {
+	if len(imk) != 16 {
+		return nil, ErrInvalidKey
+	}
+
+	if len(pan) == 0 || len(pan) > 19 || !isNumeric(pan) {
+		return nil, ErrInvalidPan
+	}
+
+	if psn == "" {
+		psn = "00"
+	}
+
+	if len(psn) != 2 || !isNumeric(psn) {
+		return nil, ErrInvalidPsn
+	}
+
+	x := pan + psn
+
+	var y string
+
+	if option == OptionB && len(pan) > 16 {
+		y = optionBDigits(x)
+	} else if len(x) < 16 {
+		y = strings.Repeat("0", 16-len(x)) + x
+	} else {
+		y = x[len(x)-16:]
+	}
+
+	data, _ := hex.DecodeString(y)
+	mk := make([]byte, 16)
+
+	if err := tdesEncrypt(mk[:8], data, imk); err != nil {
+		return nil, err
+	}
+
+	for i := range data {
+		data[i] ^= 0xFF
+	}
+
+	if err := tdesEncrypt(mk[8:], data, imk); err != nil {
+		return nil, err
+	}
+
+	AdjustParity(mk)
+
+	return mk, nil
+}
+
+// DeriveSessionKey derives an EMV common session key from the ICC master key
+// and the 8 byte diversification value r, as specified by EMV Book 2 A1.3.
+func DeriveSessionKey(mk, r []byte) ([]byte, error) {
+	if len(mk) != 16 {
+		return nil, ErrInvalidKey
+	}
+
+	if len(r) != 8 {
+		return nil, errors.New("Invalid diversification value")
+	}
+
+	f := make([]byte, 8)
+	sk := make([]byte, 16)
+
+	copy(f, r)
+	f[2] = 0xF0
+
+	if err := tdesEncrypt(sk[:8], f, mk); err != nil {
+		return nil, err
+	}
+
+	f[2] = 0x0F
+
+	if err := tdesEncrypt(sk[8:], f, mk); err != nil {
+		return nil, err
+	}
+
+	return sk, nil
+}
+
+// AtcDiversification returns the diversification value used to derive
+// application cryptogram session keys, the ATC followed by zeros
+func AtcDiversification(atc []byte) ([]byte, error) {
+	if len(atc) != 2 {
+		return nil, errors.New("Invalid ATC")
+	}
+
+	r := make([]byte, 8)
+	copy(r, atc)
+
+	return r, nil
+}
+
+// Mac computes the ISO 9797-1 MAC algorithm 3 with a double length key,
+// used for application cryptograms and ARPC method 2
+func Mac(key, data []byte, padding Padding) ([]byte, error) {
+	if len(key) != 16 {
+		return nil, ErrInvalidKey
+	}
+
+	return mac.RetailMac(key, data, padding == PaddingMethod2)
+}
+
+// ArpcMethod1 enciphers the ARQC XORed with the 2 byte authorization
+// response code
+func ArpcMethod1(sk, arqc, arc []byte) ([]byte, error) {
+	if len(sk) != 16 {
+		return nil, ErrInvalidKey
+	}
+
+	if len(arqc) != 8 {
+		return nil, ErrInvalidArqc
+	}
+
+	if len(arc) != 2 {
+		return nil, errors.New("Invalid authorization response code")
+	}
+
+	data := make([]byte, 8)
+
+	copy(data, arqc)
+	data[0] ^= arc[0]
+	data[1] ^= arc[1]
+
+	arpc := make([]byte, 8)
+
+	if err := tdesEncrypt(arpc, data, sk); err != nil {
+		return nil, err
+	}
+
+	return arpc, nil
+}
+
+// ArpcMethod2 returns the 4 leftmost bytes of the MAC of the ARQC, the 4
+// byte card status update and the proprietary authentication data
+func ArpcMethod2(sk, arqc, csu, proprietaryData []byte) ([]byte, error) {
+	if len(arqc) != 8 {
+		return nil, ErrInvalidArqc
+	}
+
+	if len(csu) != 4 {
+		return nil, errors.New("Invalid card status update")
+	}
+
+	if len(proprietaryData) > 8 {
+		return nil, errors.New("Invalid proprietary authentication data")
+	}
+
+	data := make([]byte, 0, 20)
+	data = append(data, arqc...)
+	data = append(data, csu...)
+	data = append(data, proprietaryData...)
+
+	mac, err := Mac(sk, data, PaddingMethod2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mac[:4], nil
+}
+
+// AdjustParity sets the least significant bit of each byte so it has an odd
+// number of one bits
+func AdjustParity(key []byte) {
+	for i, b := range key {
+		ones := 0
+
+		for v := b >> 1; v != 0; v >>= 1 {
+			ones += int(v & 1)
+		}
+
+		key[i] = b&0xFE | byte(1-ones%2)
+	}
+}
+
+// optionBDigits decimalizes the SHA-1 hash of the PAN and PSN, taking its
+// decimal digits first and then its letters converted to digits
+func optionBDigits(x string) string {
+	if len(x)%2 != 0 {
+		x = "0" + x
+	}
+
+	data, _ := hex.DecodeString(x)
+	hash := sha1.Sum(data)
+	encoded := hex.EncodeToString(hash[:])
+
+	result := make([]byte, 0, 16)
+
+	for i := 0; i < len(encoded) && len(result) < 16; i++ {
+		if encoded[i] <= '9' {
+			result = append(result, encoded[i])
+		}
+	}
+
+	for i := 0; i < len(encoded) && len(result) < 16; i++ {
+		if encoded[i] > '9' {
+			result = append(result, encoded[i]-'a'+'0')
+		}
+	}
+
+	return string(result)
+}
+
+func pad(data []byte, padding Padding) []byte {
+	padded := make([]byte, len(data), len(data)+8)
+	copy(padded, data)
+
+	if padding == PaddingMethod2 {
+		padded = append(padded, 0x80)
+	}
+
+	for len(padded)%8 != 0 || len(padded) == 0 {
+		padded = append(padded, 0)
+	}
+
+	return padded
+}
+
+func tdesEncrypt(dst, src, key []byte) error {
+	block, err := tdes.NewCipher(key)
+
+	if err != nil {
+		return err
+	}
+
+	block.Encrypt(dst, src)
+
+	return nil
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
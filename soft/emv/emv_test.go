@@ -0,0 +1,77 @@
+package emv
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testImk, _ = hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+var testTransactionData, _ = hex.DecodeString("0000000010000000000000000710000000000007101302050030901B6A3C00005503A4A082")
+
+func TestIccMasterKeyDerivation(t *testing.T) {
+	// pyemv's option A example
+	mk, err := DeriveIccMasterKey(testImk, "99012345678901234", "45", OptionA)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "67f8292358083e5ea7ab7fda58d53b6b", hex.EncodeToString(mk))
+
+	mk, err = DeriveIccMasterKey(testImk, "5413330089010434", "01", OptionA)
+
+	assert.Nil(t, err)
+
+	optionB, err := DeriveIccMasterKey(testImk, "5413330089010434", "01", OptionB)
+
+	assert.Nil(t, err)
+	assert.Equal(t, mk, optionB, "Option B only applies to long PANs")
+
+	assert.Equal(t, "8696347472013645", optionBDigits("541333008901043412301"))
+
+	mk, err = DeriveIccMasterKey(testImk, "5413330089010434123", "01", OptionB)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "c70267d043915815022576fee0f7d554", hex.EncodeToString(mk))
+
+	_, err = DeriveIccMasterKey(testImk, "5413330089010434", "1", OptionA)
+	assert.Equal(t, ErrInvalidPsn, err)
+
+	_, err = DeriveIccMasterKey(testImk[:8], "5413330089010434", "01", OptionA)
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func TestCryptograms(t *testing.T) {
+	// Session keys, cryptograms and ARPCs of pyemv's ICC master key, checked
+	// against OpenSSL's TDES and DES-CBC
+	mk, _ := hex.DecodeString("67f8292358083e5ea7ab7fda58d53b6b")
+	r, err := AtcDiversification([]byte{0x00, 0x1C})
+
+	assert.Nil(t, err)
+
+	sk, err := DeriveSessionKey(mk, r)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "38324e73f063efdd5ca88c322476a45b", hex.EncodeToString(sk))
+
+	arqc, err := Mac(sk, testTransactionData, PaddingMethod2)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "d96d9ff7093d2656", hex.EncodeToString(arqc))
+
+	arpc, err := ArpcMethod1(sk, arqc, []byte{0x30, 0x30})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "9c59c7c573f37bfe", hex.EncodeToString(arpc))
+
+	arpc, err = ArpcMethod2(sk, arqc, []byte{0x00, 0x00, 0x00, 0x00}, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "96aaa8d0", hex.EncodeToString(arpc))
+}
+
+func TestAdjustParity(t *testing.T) {
+	key := []byte{0x00, 0x01, 0x03, 0xFE, 0xFF}
+
+	AdjustParity(key)
+
+	assert.Equal(t, []byte{0x01, 0x01, 0x02, 0xFE, 0xFE}, key)
+}
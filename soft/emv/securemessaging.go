@@ -0,0 +1,90 @@
+package emv
+
+import (
+	"crypto/cipher"
+	"errors"
+
+	"github.com/pagarme/cryptokit/soft/tdes"
+)
+
+var ErrInvalidCiphertext = errors.New("Invalid secure messaging ciphertext")
+
+// SecureMessagingMac computes the issuer script MAC of data, made of the
+// command header, ATC, application cryptogram and command data, with the
+// integrity session key, truncated to length bytes
+func SecureMessagingMac(sk, data []byte, length int) ([]byte, error) {
+	if length < 4 || length > 8 {
+		return nil, errors.New("Secure messaging MACs must have between 4 and 8 bytes")
+	}
+
+	mac, err := Mac(sk, data, PaddingMethod2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mac[:length], nil
+}
+
+// EncryptSecureMessaging enciphers command data with the confidentiality
+// session key as specified by EMV Book 2 9.3, the data being prefixed by
+// its length and padded when it isn't a multiple of 8 bytes
+func EncryptSecureMessaging(sk, data []byte, ecb bool) ([]byte, error) {
+	if len(data) > 0xFF {
+		return nil, errors.New("Secure messaging data is too long")
+	}
+
+	block, err := tdes.NewCipher(sk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := append([]byte{byte(len(data))}, data...)
+
+	if len(plaintext)%8 != 0 {
+		plaintext = pad(plaintext, PaddingMethod2)
+	}
+
+	ciphertext := make([]byte, len(plaintext))
+
+	if ecb {
+		for i := 0; i < len(plaintext); i += 8 {
+			block.Encrypt(ciphertext[i:i+8], plaintext[i:i+8])
+		}
+	} else {
+		cipher.NewCBCEncrypter(block, make([]byte, 8)).CryptBlocks(ciphertext, plaintext)
+	}
+
+	return ciphertext, nil
+}
+
+func DecryptSecureMessaging(sk, ciphertext []byte, ecb bool) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%8 != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+
+	block, err := tdes.NewCipher(sk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+
+	if ecb {
+		for i := 0; i < len(ciphertext); i += 8 {
+			block.Decrypt(plaintext[i:i+8], ciphertext[i:i+8])
+		}
+	} else {
+		cipher.NewCBCDecrypter(block, make([]byte, 8)).CryptBlocks(plaintext, ciphertext)
+	}
+
+	length := int(plaintext[0])
+
+	if length+1 > len(plaintext) {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return plaintext[1 : length+1], nil
+}
@@ -0,0 +1,55 @@
+package emv
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testSk, _ = hex.DecodeString("c9a56f198477a6945a48f04eba5154be")
+
+func TestSecureMessagingMac(t *testing.T) {
+	data, _ := hex.DecodeString("8424000010001ce64a5c4008503cc4")
+
+	mac, err := SecureMessagingMac(testSk, data, 8)
+
+	assert.Nil(t, err)
+
+	expected, _ := Mac(testSk, data, PaddingMethod2)
+
+	assert.Equal(t, expected, mac)
+
+	mac, err = SecureMessagingMac(testSk, data, 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected[:4], mac)
+
+	_, err = SecureMessagingMac(testSk, data, 3)
+
+	assert.NotNil(t, err)
+}
+
+func TestSecureMessagingEncryption(t *testing.T) {
+	pinBlock, _ := hex.DecodeString("241234ffffffffff")
+
+	for _, ecb := range []bool{false, true} {
+		ciphertext, err := EncryptSecureMessaging(testSk, pinBlock, ecb)
+
+		assert.Nil(t, err)
+		assert.Len(t, ciphertext, 16)
+
+		plaintext, err := DecryptSecureMessaging(testSk, ciphertext, ecb)
+
+		assert.Nil(t, err)
+		assert.Equal(t, pinBlock, plaintext)
+	}
+
+	ciphertext, err := EncryptSecureMessaging(testSk, pinBlock[:7], false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "175ed545d749dcc1", hex.EncodeToString(ciphertext))
+
+	_, err = DecryptSecureMessaging(testSk, ciphertext[:7], false)
+
+	assert.Equal(t, ErrInvalidCiphertext, err)
+}
@@ -0,0 +1,50 @@
+package soft
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/fpe"
+)
+
+func processFpe(mech cryptokit.Mechanism, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
+	if key.Type() != cryptokit.AesKey {
+		return nil, errors.New("Format-preserving encryption requires AES keys")
+	}
+
+	data := key.(*Key).data
+
+	var out string
+	var err error
+
+	switch v := mech.(type) {
+	case cryptokit.Ff1:
+		if encrypt {
+			out, err = fpe.Ff1Encrypt(data, v.Tweak, getRadix(v.Radix), string(in))
+		} else {
+			out, err = fpe.Ff1Decrypt(data, v.Tweak, getRadix(v.Radix), string(in))
+		}
+	case cryptokit.Ff31:
+		if encrypt {
+			out, err = fpe.Ff31Encrypt(data, v.Tweak, getRadix(v.Radix), string(in))
+		} else {
+			out, err = fpe.Ff31Decrypt(data, v.Tweak, getRadix(v.Radix), string(in))
+		}
+	default:
+		return nil, errors.New("Unknown mechanism")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(out), nil
+}
+
+func getRadix(radix int) int {
+	if radix == 0 {
+		return 10
+	}
+
+	return radix
+}
@@ -0,0 +1,323 @@
+package fpe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Numerals of radixes up to 36
+const Alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+const (
+	ff1Rounds = 10
+	ff3Rounds = 8
+	Ff31Tweak = 7
+	minDomain = 1000000
+)
+
+var (
+	ErrInvalidRadix   = errors.New("Radix must be between 2 and 36")
+	ErrInvalidLength  = errors.New("Invalid input length for the radix")
+	ErrInvalidNumeral = errors.New("Input has numerals outside the radix")
+	ErrInvalidTweak   = errors.New("Invalid tweak length")
+)
+
+// Ff1Encrypt enciphers the numeral string x with NIST SP 800-38G FF1 under
+// an AES key
+func Ff1Encrypt(key, tweak []byte, radix int, x string) (string, error) {
+	return ff1(key, tweak, radix, x, true)
+}
+
+func Ff1Decrypt(key, tweak []byte, radix int, x string) (string, error) {
+	return ff1(key, tweak, radix, x, false)
+}
+
+// Ff31Encrypt enciphers the numeral string x with NIST SP 800-38G Rev. 1
+// FF3-1 under an AES key and a 7 byte tweak
+func Ff31Encrypt(key, tweak []byte, radix int, x string) (string, error) {
+	return ff31(key, tweak, radix, x, true)
+}
+
+func Ff31Decrypt(key, tweak []byte, radix int, x string) (string, error) {
+	return ff31(key, tweak, radix, x, false)
+}
+
+func ff1(key, tweak []byte, radix int, x string, encrypt bool) (string, error) {
+	numerals, err := parse(radix, x)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(x) < 2 || !checkDomain(radix, len(x)) {
+		return "", ErrInvalidLength
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	n := len(numerals)
+	u := n / 2
+	v := n - u
+	a, b := numerals[:u], numerals[u:]
+
+	bigRadix := big.NewInt(int64(radix))
+	byteLength := int(math.Ceil(math.Ceil(float64(v)*math.Log2(float64(radix))) / 8))
+	d := 4*((byteLength+3)/4) + 4
+
+	p := []byte{1, 2, 1, byte(radix >> 16), byte(radix >> 8), byte(radix), 10, byte(u)}
+	p = append(p, uint32Bytes(n)...)
+	p = append(p, uint32Bytes(len(tweak))...)
+
+	padding := (16 - (len(tweak)+byteLength+1)%16) % 16
+	q := make([]byte, len(tweak)+padding+1+byteLength)
+	copy(q, tweak)
+
+	for round := 0; round < ff1Rounds; round++ {
+		i := round
+		source := b
+
+		if !encrypt {
+			i = ff1Rounds - 1 - round
+			source = a
+		}
+
+		q[len(tweak)+padding] = byte(i)
+		fill(q[len(q)-byteLength:], num(source, bigRadix).Bytes())
+
+		r := cbcMac(block, append(append([]byte{}, p...), q...))
+		s := expand(block, r, d)
+		y := new(big.Int).SetBytes(s)
+
+		m := u
+
+		if i%2 == 1 {
+			m = v
+		}
+
+		modulus := new(big.Int).Exp(bigRadix, big.NewInt(int64(m)), nil)
+
+		if encrypt {
+			c := num(a, bigRadix)
+			c.Add(c, y).Mod(c, modulus)
+			a, b = b, str(c, bigRadix, m)
+		} else {
+			c := num(b, bigRadix)
+			c.Sub(c, y).Mod(c, modulus)
+			a, b = str(c, bigRadix, m), a
+		}
+	}
+
+	return format(append(append([]int{}, a...), b...)), nil
+}
+
+func ff31(key, tweak []byte, radix int, x string, encrypt bool) (string, error) {
+	if len(tweak) != Ff31Tweak {
+		return "", ErrInvalidTweak
+	}
+
+	// The 56 bit tweak is split in two 32 bit halves, the middle nibble
+	// going into both
+	left := []byte{tweak[0], tweak[1], tweak[2], tweak[3] & 0xF0}
+	right := []byte{tweak[4], tweak[5], tweak[6], tweak[3] << 4}
+
+	return ff3(key, left, right, radix, x, encrypt)
+}
+
+func ff3(key, left, right []byte, radix int, x string, encrypt bool) (string, error) {
+	numerals, err := parse(radix, x)
+
+	if err != nil {
+		return "", err
+	}
+
+	maxLength := 2 * int(math.Floor(96/math.Log2(float64(radix))))
+
+	if len(x) < 2 || len(x) > maxLength || !checkDomain(radix, len(x)) {
+		return "", ErrInvalidLength
+	}
+
+	block, err := aes.NewCipher(reverseBytes(key))
+
+	if err != nil {
+		return "", err
+	}
+
+	n := len(numerals)
+	u := (n + 1) / 2
+	v := n - u
+	a, b := numerals[:u], numerals[u:]
+
+	bigRadix := big.NewInt(int64(radix))
+	p := make([]byte, 16)
+
+	for round := 0; round < ff3Rounds; round++ {
+		i := round
+		source := b
+
+		if !encrypt {
+			i = ff3Rounds - 1 - round
+			source = a
+		}
+
+		m, w := u, right
+
+		if i%2 == 1 {
+			m, w = v, left
+		}
+
+		copy(p, w)
+		p[3] ^= byte(i)
+		fill(p[4:], num(reverseInts(source), bigRadix).Bytes())
+
+		s := make([]byte, 16)
+		block.Encrypt(s, reverseBytes(p))
+
+		y := new(big.Int).SetBytes(reverseBytes(s))
+		modulus := new(big.Int).Exp(bigRadix, big.NewInt(int64(m)), nil)
+
+		if encrypt {
+			c := num(reverseInts(a), bigRadix)
+			c.Add(c, y).Mod(c, modulus)
+			a, b = b, reverseInts(str(c, bigRadix, m))
+		} else {
+			c := num(reverseInts(b), bigRadix)
+			c.Sub(c, y).Mod(c, modulus)
+			a, b = reverseInts(str(c, bigRadix, m)), a
+		}
+	}
+
+	return format(append(append([]int{}, a...), b...)), nil
+}
+
+// checkDomain reports whether radix^length reaches the minimum domain size
+func checkDomain(radix, length int) bool {
+	domain := new(big.Int).Exp(big.NewInt(int64(radix)), big.NewInt(int64(length)), nil)
+
+	return domain.Cmp(big.NewInt(minDomain)) >= 0
+}
+
+func parse(radix int, x string) ([]int, error) {
+	if radix < 2 || radix > len(Alphabet) {
+		return nil, ErrInvalidRadix
+	}
+
+	result := make([]int, len(x))
+
+	for i := range x {
+		result[i] = strings.IndexByte(Alphabet[:radix], x[i])
+
+		if result[i] < 0 {
+			return nil, ErrInvalidNumeral
+		}
+	}
+
+	return result, nil
+}
+
+func format(numerals []int) string {
+	result := make([]byte, len(numerals))
+
+	for i, n := range numerals {
+		result[i] = Alphabet[n]
+	}
+
+	return string(result)
+}
+
+func num(numerals []int, radix *big.Int) *big.Int {
+	result := new(big.Int)
+
+	for _, n := range numerals {
+		result.Mul(result, radix).Add(result, big.NewInt(int64(n)))
+	}
+
+	return result
+}
+
+func str(x *big.Int, radix *big.Int, m int) []int {
+	result := make([]int, m)
+	x = new(big.Int).Set(x)
+	digit := new(big.Int)
+
+	for i := m - 1; i >= 0; i-- {
+		x.DivMod(x, radix, digit)
+		result[i] = int(digit.Int64())
+	}
+
+	return result
+}
+
+// expand returns the first d bytes of r followed by r XORed with 1, 2, ...
+// enciphered
+func expand(block cipher.Block, r []byte, d int) []byte {
+	s := append([]byte{}, r...)
+
+	for j := 1; len(s) < d; j++ {
+		counter := make([]byte, 16)
+		fill(counter, big.NewInt(int64(j)).Bytes())
+
+		for k := range counter {
+			counter[k] ^= r[k]
+		}
+
+		block.Encrypt(counter, counter)
+		s = append(s, counter...)
+	}
+
+	return s[:d]
+}
+
+func cbcMac(block cipher.Block, data []byte) []byte {
+	mac := make([]byte, 16)
+
+	for i := 0; i < len(data); i += 16 {
+		for j := 0; j < 16; j++ {
+			mac[j] ^= data[i+j]
+		}
+
+		block.Encrypt(mac, mac)
+	}
+
+	return mac
+}
+
+// fill stores the big endian value right aligned in dst
+func fill(dst, value []byte) {
+	for i := range dst {
+		dst[i] = 0
+	}
+
+	copy(dst[len(dst)-len(value):], value)
+}
+
+func uint32Bytes(v int) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func reverseBytes(b []byte) []byte {
+	result := make([]byte, len(b))
+
+	for i := range b {
+		result[len(b)-1-i] = b[i]
+	}
+
+	return result
+}
+
+func reverseInts(n []int) []int {
+	result := make([]int, len(n))
+
+	for i := range n {
+		result[len(n)-1-i] = n[i]
+	}
+
+	return result
+}
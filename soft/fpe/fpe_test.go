@@ -0,0 +1,131 @@
+package fpe
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// NIST SP 800-38G samples
+func TestFf1(t *testing.T) {
+	vectors := []struct {
+		key, tweak string
+		radix      int
+		plaintext  string
+		ciphertext string
+	}{
+		{"2b7e151628aed2a6abf7158809cf4f3c", "", 10, "0123456789", "2433477484"},
+		{"2b7e151628aed2a6abf7158809cf4f3c", "39383736353433323130", 10, "0123456789", "6124200773"},
+		{"2b7e151628aed2a6abf7158809cf4f3c", "3737373770717273373737", 36, "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+		{"2b7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f", "", 10, "0123456789", "2830668132"},
+		{"2b7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f7f036d6f04fc6a94", "", 10, "0123456789", "6657667009"},
+	}
+
+	for _, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		tweak, _ := hex.DecodeString(v.tweak)
+
+		ciphertext, err := Ff1Encrypt(key, tweak, v.radix, v.plaintext)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v.ciphertext, ciphertext)
+
+		plaintext, err := Ff1Decrypt(key, tweak, v.radix, ciphertext)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v.plaintext, plaintext)
+	}
+}
+
+// NIST SP 800-38G FF3 samples, exercising the rounds FF3-1 shares with FF3
+func TestFf3(t *testing.T) {
+	key, _ := hex.DecodeString("ef4359d8d580aa4f7f036d6f04fc6a94")
+
+	vectors := []struct {
+		tweak      string
+		plaintext  string
+		ciphertext string
+	}{
+		{"d8e7920afa330a73", "890121234567890000", "750918814058654607"},
+		{"9a768a92f60e12d8", "890121234567890000", "018989839189395384"},
+	}
+
+	for _, v := range vectors {
+		tweak, _ := hex.DecodeString(v.tweak)
+
+		ciphertext, err := ff3(key, tweak[:4], tweak[4:], 10, v.plaintext, true)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v.ciphertext, ciphertext)
+
+		plaintext, err := ff3(key, tweak[:4], tweak[4:], 10, ciphertext, false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v.plaintext, plaintext)
+	}
+}
+
+// NIST ACVP FF3-1 samples
+func TestFf31(t *testing.T) {
+	vectors := []struct {
+		key, tweak string
+		plaintext  string
+		ciphertext string
+	}{
+		{"2de79d232df5585d68ce47882ae256d6", "cbd09280979564", "3992520240", "8901801106"},
+		{"01c63017111438f7fc8e24eb16c71ab5", "c4e822dcd09f27", "60761757463116869318437658042297305934914824457484538562", "35637144092473838892796702739628394376915177448290847293"},
+	}
+
+	for _, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		tweak, _ := hex.DecodeString(v.tweak)
+
+		ciphertext, err := Ff31Encrypt(key, tweak, 10, v.plaintext)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v.ciphertext, ciphertext)
+
+		plaintext, err := Ff31Decrypt(key, tweak, 10, ciphertext)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v.plaintext, plaintext)
+	}
+
+	key, _ := hex.DecodeString("ef4359d8d580aa4f7f036d6f04fc6a94")
+	tweak, _ := hex.DecodeString("d8e7920afa330a")
+
+	ciphertext, err := Ff31Encrypt(key, tweak, 10, "4111111111111111")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 16, len(ciphertext))
+	assert.NotEqual(t, "4111111111111111", ciphertext)
+
+	plaintext, err := Ff31Decrypt(key, tweak, 10, ciphertext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "4111111111111111", plaintext)
+
+	_, err = Ff31Encrypt(key, tweak[:6], 10, "4111111111111111")
+
+	assert.Equal(t, ErrInvalidTweak, err)
+}
+
+func TestInvalidInputs(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+
+	_, err := Ff1Encrypt(key, nil, 10, "12345")
+
+	assert.Equal(t, ErrInvalidLength, err)
+
+	_, err = Ff1Encrypt(key, nil, 10, "012345678a")
+
+	assert.Equal(t, ErrInvalidNumeral, err)
+
+	_, err = Ff1Encrypt(key, nil, 37, "0123456789")
+
+	assert.Equal(t, ErrInvalidRadix, err)
+
+	_, err = Ff31Encrypt(key, make([]byte, 7), 10, "012345678901234567890123456789012345678901234567890123456")
+
+	assert.Equal(t, ErrInvalidLength, err)
+}
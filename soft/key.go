@@ -13,6 +13,7 @@ type Key struct {
 	permanent    bool
 	extractable  bool
 	capabilities cryptokit.KeyCapability
+	metadata     map[string]string
 	session      *Session
 	data         []byte
 }
@@ -25,6 +26,7 @@ func newKey(s *Session, a cryptokit.KeyAttributes, data []byte) *Key {
 		extractable:  a.Extractable,
 		permanent:    a.Permanent,
 		capabilities: a.Capabilities,
+		metadata:     a.Metadata,
 		session:      s,
 		data:         data,
 	}
@@ -33,6 +35,16 @@ func newKey(s *Session, a cryptokit.KeyAttributes, data []byte) *Key {
 func loadKey(s *Session, a map[string]interface{}) *Key {
 	data, _ := base64.StdEncoding.DecodeString(a["data"].(string))
 
+	var metadata map[string]string
+
+	if m, ok := a["metadata"].(map[string]interface{}); ok {
+		metadata = make(map[string]string, len(m))
+
+		for k, v := range m {
+			metadata[k], _ = v.(string)
+		}
+	}
+
 	return &Key{
 		id:           a["id"].(string),
 		typ:          cryptokit.KeyType(a["type"].(float64)),
@@ -40,6 +52,7 @@ func loadKey(s *Session, a map[string]interface{}) *Key {
 		extractable:  a["extractable"].(bool),
 		permanent:    a["permanent"].(bool),
 		capabilities: cryptokit.KeyCapability(a["capabilities"].(float64)),
+		metadata:     metadata,
 		session:      s,
 		data:         data,
 	}
@@ -65,6 +78,7 @@ func (k *Key) Attributes() cryptokit.KeyAttributes {
 		Permanent:    k.permanent,
 		Extractable:  k.extractable,
 		Capabilities: k.capabilities,
+		Metadata:     k.metadata,
 	}
 }
 
@@ -92,6 +106,43 @@ func (k *Key) Close() error {
 	return nil
 }
 
+// Metadata the provider relies on can only be set by the provider itself
+var reservedMetadata = []string{
+	cryptokit.DukptInitialKsnMetadata,
+	cryptokit.Tr31KeyUsageMetadata,
+	cryptokit.Tr31ExportabilityMetadata,
+}
+
+func checkMetadata(a cryptokit.KeyAttributes) error {
+	for _, key := range reservedMetadata {
+		if _, ok := a.Metadata[key]; ok {
+			return errors.New("Reserved metadata can only be set by the provider")
+		}
+	}
+
+	return nil
+}
+
+func checkExportable(k *Key) error {
+	if e, ok := k.metadata[cryptokit.Tr31ExportabilityMetadata]; ok && e != "E" {
+		return errors.New("Key isn't exportable")
+	}
+
+	return nil
+}
+
+func withMetadata(metadata map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(metadata)+1)
+
+	for k, v := range metadata {
+		result[k] = v
+	}
+
+	result[key] = value
+
+	return result
+}
+
 func (k *Key) save() error {
 	attributes := map[string]interface{}{
 		"id":           k.id,
@@ -100,6 +151,7 @@ func (k *Key) save() error {
 		"extractable":  k.extractable,
 		"permanent":    k.permanent,
 		"capabilities": k.capabilities,
+		"metadata":     k.metadata,
 		"data":         base64.StdEncoding.EncodeToString(k.data),
 	}
 
@@ -0,0 +1,39 @@
+package soft
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/variant"
+)
+
+// Thales key type variants of each key class under its LMK pair, the pair
+// itself telling most classes apart
+var keyClassVariants = map[cryptokit.KeyClass]int{
+	cryptokit.ZmkClass: 0,
+	cryptokit.ZpkClass: 0,
+	cryptokit.TakClass: 0,
+	cryptokit.CvkClass: 4,
+}
+
+func processKeyVariant(mech cryptokit.KeyVariant, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
+	v, ok := keyClassVariants[mech.Class]
+
+	if !ok {
+		return nil, errors.New("Unknown key class")
+	}
+
+	if key.Type() != cryptokit.TdesKey {
+		return nil, variant.ErrInvalidKek
+	}
+
+	if !mech.Lmk {
+		v = 0
+	}
+
+	if encrypt {
+		return variant.Encrypt(key.(*Key).data, in, v, mech.X917)
+	}
+
+	return variant.Decrypt(key.(*Key).data, in, v, mech.X917)
+}
@@ -0,0 +1,46 @@
+package soft
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/mac"
+)
+
+func processRetailMac(mech cryptokit.RetailMac, key cryptokit.Key, in []byte) ([]byte, error) {
+	if key.Type() != cryptokit.TdesKey {
+		return nil, mac.ErrInvalidKey
+	}
+
+	result, err := mac.RetailMac(key.(*Key).data, in, mech.Method2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return truncateMac(result, mech.Length)
+}
+
+func processCmac(mech cryptokit.Cmac, key cryptokit.Key, in []byte) ([]byte, error) {
+	impl, err := getKeyImplementation(key.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return truncateMac(mac.Cmac(impl, in), mech.Length)
+}
+
+// truncateMac keeps the leftmost length bytes of mac, all of them when
+// length is zero
+func truncateMac(mac []byte, length int) ([]byte, error) {
+	if length == 0 {
+		return mac, nil
+	}
+
+	if length < 4 || length > len(mac) {
+		return nil, errors.New("Invalid MAC length")
+	}
+
+	return mac[:length], nil
+}
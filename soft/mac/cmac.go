@@ -0,0 +1,83 @@
+package mac
+
+import (
+	"crypto/cipher"
+)
+
+// Cmac computes the NIST SP 800-38B CMAC of data with a 64 or 128 bit block
+// cipher
+func Cmac(block cipher.Block, data []byte) []byte {
+	size := block.BlockSize()
+	k1, k2 := cmacSubkeys(block)
+
+	blocks := (len(data) + size - 1) / size
+	last := make([]byte, size)
+
+	if blocks == 0 {
+		blocks = 1
+	}
+
+	if len(data) > 0 && len(data)%size == 0 {
+		copy(last, data[(blocks-1)*size:])
+		xorBytes(last, last, k1)
+	} else {
+		rest := data[(blocks-1)*size:]
+
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBytes(last, last, k2)
+	}
+
+	result := make([]byte, size)
+
+	for i := 0; i < blocks-1; i++ {
+		xorBytes(result, result, data[i*size:(i+1)*size])
+		block.Encrypt(result, result)
+	}
+
+	xorBytes(result, result, last)
+	block.Encrypt(result, result)
+
+	return result
+}
+
+func cmacSubkeys(block cipher.Block) ([]byte, []byte) {
+	size := block.BlockSize()
+	l := make([]byte, size)
+
+	block.Encrypt(l, l)
+
+	k1 := shiftSubkey(l)
+	k2 := shiftSubkey(k1)
+
+	return k1, k2
+}
+
+// shiftSubkey doubles the value in GF(2^n), the reduction constant being
+// 0x87 for 128 bit blocks and 0x1B for 64 bit ones
+func shiftSubkey(v []byte) []byte {
+	result := make([]byte, len(v))
+	carry := v[0] >> 7
+
+	for i := 0; i < len(v)-1; i++ {
+		result[i] = v[i]<<1 | v[i+1]>>7
+	}
+
+	result[len(v)-1] = v[len(v)-1] << 1
+
+	if carry == 1 {
+		if len(v) == 16 {
+			result[len(v)-1] ^= 0x87
+		} else {
+			result[len(v)-1] ^= 0x1B
+		}
+	}
+
+	return result
+}
+
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,30 @@
+package mac
+
+import (
+	"crypto/aes"
+	"crypto/des"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// NIST SP 800-38B examples
+func TestAesCmac(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	message, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411")
+	block, _ := aes.NewCipher(key)
+
+	assert.Equal(t, "bb1d6929e95937287fa37d129b756746", hex.EncodeToString(Cmac(block, nil)))
+	assert.Equal(t, "070a16b46b4d4144f79bdd9dd04a287c", hex.EncodeToString(Cmac(block, message[:16])))
+	assert.Equal(t, "dfa66747de9ae63030ca32611497c827", hex.EncodeToString(Cmac(block, message[:40])))
+}
+
+func TestTdesCmac(t *testing.T) {
+	key, _ := hex.DecodeString("8aa83bf8cbda10620bc1bf19fbb6cd58bc313d4a371ca8b5")
+	message, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c")
+	block, _ := des.NewTripleDESCipher(key)
+
+	assert.Equal(t, "b7a688e122ffaf95", hex.EncodeToString(Cmac(block, nil)))
+	assert.Equal(t, "8e8f293136283797", hex.EncodeToString(Cmac(block, message[:8])))
+	assert.Equal(t, "743ddbe0ce2dc2ed", hex.EncodeToString(Cmac(block, message[:20])))
+}
@@ -0,0 +1,51 @@
+package mac
+
+import (
+	"crypto/des"
+	"errors"
+)
+
+var ErrInvalidKey = errors.New("Retail MAC keys must be 16 bytes long")
+
+// RetailMac computes the ANSI X9.19 retail MAC, ISO 9797-1 MAC algorithm 3,
+// of data with a double length TDES key. Data is padded with zeros or, when
+// method2 is set, with ISO 9797-1 padding method 2.
+func RetailMac(key, data []byte, method2 bool) ([]byte, error) {
+	if len(key) != 16 {
+		return nil, ErrInvalidKey
+	}
+
+	left, err := des.NewCipher(key[:8])
+
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := des.NewCipher(key[8:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	padded := append([]byte{}, data...)
+
+	if method2 {
+		padded = append(padded, 0x80)
+	}
+
+	if len(padded)%8 != 0 || len(padded) == 0 {
+		padded = append(padded, make([]byte, 8-len(padded)%8)...)
+	}
+
+	mac := make([]byte, 8)
+
+	for i := 0; i < len(padded); i += 8 {
+		xorBytes(mac, mac, padded[i:i+8])
+		left.Encrypt(mac, mac)
+	}
+
+	right.Decrypt(mac, mac)
+	left.Encrypt(mac, mac)
+
+	return mac, nil
+}
@@ -0,0 +1,26 @@
+package mac
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRetailMac(t *testing.T) {
+	key, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	data := []byte("Now is the time for all ")
+
+	mac, err := RetailMac(key, data, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "a1c72e74ea3fa9b6", hex.EncodeToString(mac))
+
+	mac, err = RetailMac(key, data, true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "e9086230ca3be796", hex.EncodeToString(mac))
+
+	_, err = RetailMac(key[:8], data, false)
+
+	assert.Equal(t, ErrInvalidKey, err)
+}
@@ -0,0 +1,370 @@
+package pin
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+const (
+	MinPinLength = 4
+	MaxPinLength = 12
+)
+
+var (
+	ErrInvalidPin      = errors.New("Invalid PIN")
+	ErrInvalidPan      = errors.New("Invalid PAN")
+	ErrInvalidFormat   = errors.New("Unsupported PIN block format")
+	ErrInvalidPinBlock = errors.New("Invalid PIN block")
+)
+
+// Encrypt builds the ISO 9564 PIN block of the given format and enciphers it
+// with block. Formats 0, 1 and 3 require a 64 bit cipher and format 4 an AES
+// cipher. The PAN is ignored by format 1.
+func Encrypt(block cipher.Block, format int, pin, pan string) ([]byte, error) {
+	if err := checkCipher(block, format); err != nil {
+		return nil, err
+	}
+
+	if format == 4 {
+		return encryptFormat4(block, pin, pan)
+	}
+
+	clear, err := encodeBlock(format, pin, pan)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 8)
+	block.Encrypt(result, clear)
+
+	return result, nil
+}
+
+// Decrypt deciphers an ISO 9564 PIN block and returns the PIN it carries.
+func Decrypt(block cipher.Block, format int, ciphertext []byte, pan string) (string, error) {
+	if err := checkCipher(block, format); err != nil {
+		return "", err
+	}
+
+	if len(ciphertext) != block.BlockSize() {
+		return "", ErrInvalidPinBlock
+	}
+
+	if format == 4 {
+		return decryptFormat4(block, ciphertext, pan)
+	}
+
+	clear := make([]byte, 8)
+	block.Decrypt(clear, ciphertext)
+
+	return decodeBlock(format, clear, pan)
+}
+
+// EncodeFormat2 builds the plaintext ISO 9564 format 2 PIN block used by
+// ICC PIN change and offline PIN verification.
+func EncodeFormat2(pin string) ([]byte, error) {
+	return encodeBlock(2, pin, "")
+}
+
+func checkCipher(block cipher.Block, format int) error {
+	switch format {
+	case 0, 1, 3:
+		if block.BlockSize() != 8 {
+			return errors.New("PIN block format requires a 64 bit block cipher")
+		}
+	case 4:
+		if block.BlockSize() != 16 {
+			return errors.New("PIN block format 4 requires a 128 bit block cipher")
+		}
+	default:
+		return ErrInvalidFormat
+	}
+
+	return nil
+}
+
+func encodeBlock(format int, pin, pan string) ([]byte, error) {
+	if err := checkPin(pin); err != nil {
+		return nil, err
+	}
+
+	nibbles := make([]byte, 16)
+	nibbles[0] = byte(format)
+	nibbles[1] = byte(len(pin))
+
+	for i := 0; i < len(pin); i++ {
+		nibbles[2+i] = pin[i] - '0'
+	}
+
+	fill := nibbles[2+len(pin):]
+
+	switch format {
+	case 0, 2:
+		for i := range fill {
+			fill[i] = 0xF
+		}
+	case 1:
+		if err := randomNibbles(fill, 0x0, 0xF); err != nil {
+			return nil, err
+		}
+	case 3:
+		if err := randomNibbles(fill, 0xA, 0xF); err != nil {
+			return nil, err
+		}
+	}
+
+	block := packNibbles(nibbles)
+
+	if format == 1 || format == 2 {
+		return block, nil
+	}
+
+	panField, err := buildPanField(pan)
+
+	if err != nil {
+		return nil, err
+	}
+
+	xorBytes(block, block, panField)
+
+	return block, nil
+}
+
+func decodeBlock(format int, block []byte, pan string) (string, error) {
+	clear := make([]byte, 8)
+	copy(clear, block)
+
+	if format != 1 {
+		panField, err := buildPanField(pan)
+
+		if err != nil {
+			return "", err
+		}
+
+		xorBytes(clear, clear, panField)
+	}
+
+	nibbles := unpackNibbles(clear)
+
+	if int(nibbles[0]) != format {
+		return "", ErrInvalidPinBlock
+	}
+
+	pin, err := extractPin(nibbles)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, n := range nibbles[2+len(pin):] {
+		if format == 0 && n != 0xF {
+			return "", ErrInvalidPinBlock
+		}
+
+		if format == 3 && n < 0xA {
+			return "", ErrInvalidPinBlock
+		}
+	}
+
+	return pin, nil
+}
+
+// Format 4 is enciphered twice, the intermediate block being XORed with the
+// PAN field between both operations.
+func encryptFormat4(block cipher.Block, pin, pan string) ([]byte, error) {
+	if err := checkPin(pin); err != nil {
+		return nil, err
+	}
+
+	panField, err := buildFormat4PanField(pan)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nibbles := make([]byte, 16)
+	nibbles[0] = 4
+	nibbles[1] = byte(len(pin))
+
+	for i := 0; i < len(pin); i++ {
+		nibbles[2+i] = pin[i] - '0'
+	}
+
+	for i := 2 + len(pin); i < 16; i++ {
+		nibbles[i] = 0xA
+	}
+
+	pinField := make([]byte, 16)
+	copy(pinField, packNibbles(nibbles))
+
+	if _, err := rand.Read(pinField[8:]); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 16)
+
+	block.Encrypt(result, pinField)
+	xorBytes(result, result, panField)
+	block.Encrypt(result, result)
+
+	return result, nil
+}
+
+func decryptFormat4(block cipher.Block, ciphertext []byte, pan string) (string, error) {
+	panField, err := buildFormat4PanField(pan)
+
+	if err != nil {
+		return "", err
+	}
+
+	pinField := make([]byte, 16)
+
+	block.Decrypt(pinField, ciphertext)
+	xorBytes(pinField, pinField, panField)
+	block.Decrypt(pinField, pinField)
+
+	nibbles := unpackNibbles(pinField[:8])
+
+	if nibbles[0] != 4 {
+		return "", ErrInvalidPinBlock
+	}
+
+	pin, err := extractPin(nibbles)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, n := range nibbles[2+len(pin):] {
+		if n != 0xA {
+			return "", ErrInvalidPinBlock
+		}
+	}
+
+	return pin, nil
+}
+
+// The PAN field used by formats 0 and 3 is made of the 12 rightmost PAN
+// digits excluding the check digit.
+func buildPanField(pan string) ([]byte, error) {
+	if err := checkDigits(pan); err != nil || len(pan) < 13 {
+		return nil, ErrInvalidPan
+	}
+
+	digits := pan[len(pan)-13 : len(pan)-1]
+	nibbles := make([]byte, 16)
+
+	for i := 0; i < len(digits); i++ {
+		nibbles[4+i] = digits[i] - '0'
+	}
+
+	return packNibbles(nibbles), nil
+}
+
+// The format 4 PAN field holds the PAN length minus 12 followed by the whole
+// PAN, PANs shorter than 12 digits being left padded with zeros.
+func buildFormat4PanField(pan string) ([]byte, error) {
+	if err := checkDigits(pan); err != nil || len(pan) == 0 || len(pan) > 19 {
+		return nil, ErrInvalidPan
+	}
+
+	nibbles := make([]byte, 32)
+	offset := 1
+
+	if len(pan) > 12 {
+		nibbles[0] = byte(len(pan) - 12)
+	} else {
+		offset += 12 - len(pan)
+	}
+
+	for i := 0; i < len(pan); i++ {
+		nibbles[offset+i] = pan[i] - '0'
+	}
+
+	return packNibbles(nibbles), nil
+}
+
+func extractPin(nibbles []byte) (string, error) {
+	length := int(nibbles[1])
+
+	if length < MinPinLength || length > MaxPinLength {
+		return "", ErrInvalidPinBlock
+	}
+
+	pin := make([]byte, length)
+
+	for i := range pin {
+		if nibbles[2+i] > 9 {
+			return "", ErrInvalidPinBlock
+		}
+
+		pin[i] = '0' + nibbles[2+i]
+	}
+
+	return string(pin), nil
+}
+
+func checkPin(pin string) error {
+	if len(pin) < MinPinLength || len(pin) > MaxPinLength {
+		return ErrInvalidPin
+	}
+
+	if err := checkDigits(pin); err != nil {
+		return ErrInvalidPin
+	}
+
+	return nil
+}
+
+func checkDigits(s string) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return errors.New("Invalid digit")
+		}
+	}
+
+	return nil
+}
+
+func randomNibbles(dst []byte, min, max byte) error {
+	buf := make([]byte, len(dst))
+
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+
+	for i, b := range buf {
+		dst[i] = min + b%(max-min+1)
+	}
+
+	return nil
+}
+
+func packNibbles(nibbles []byte) []byte {
+	result := make([]byte, len(nibbles)/2)
+
+	for i := range result {
+		result[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+
+	return result
+}
+
+func unpackNibbles(data []byte) []byte {
+	result := make([]byte, len(data)*2)
+
+	for i, b := range data {
+		result[2*i] = b >> 4
+		result[2*i+1] = b & 0x0F
+	}
+
+	return result
+}
+
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,121 @@
+package pin
+
+import (
+	"crypto/aes"
+	"crypto/des"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTdesKey = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10, 0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}
+var testAesKey = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+var testPan = "4111111111111111"
+
+func TestFormat0(t *testing.T) {
+	block, _ := des.NewTripleDESCipher(testTdesKey)
+
+	ciphertext, err := Encrypt(block, 0, "1234", testPan)
+
+	assert.Nil(t, err)
+	assert.Len(t, ciphertext, 8)
+
+	clear := make([]byte, 8)
+	block.Decrypt(clear, ciphertext)
+
+	assert.Equal(t, "041225eeeeeeeeee", hex.EncodeToString(clear))
+
+	pin, err := Decrypt(block, 0, ciphertext, testPan)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1234", pin)
+
+	_, err = Decrypt(block, 0, ciphertext, "5452300551227189")
+
+	assert.NotNil(t, err, "Decrypting with the wrong PAN should fail")
+}
+
+func TestFormat1And3(t *testing.T) {
+	block, _ := des.NewTripleDESCipher(testTdesKey)
+
+	for _, format := range []int{1, 3} {
+		ciphertext, err := Encrypt(block, format, "123456789012", testPan)
+
+		assert.Nil(t, err)
+
+		clear := make([]byte, 8)
+		block.Decrypt(clear, ciphertext)
+
+		assert.Equal(t, byte(format<<4|12), clear[0])
+
+		pin, err := Decrypt(block, format, ciphertext, testPan)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "123456789012", pin)
+	}
+}
+
+func TestFormat4(t *testing.T) {
+	block, _ := aes.NewCipher(testAesKey)
+	pan := "1234567890123456789"
+
+	ciphertext, err := Encrypt(block, 4, "1234", pan)
+
+	assert.Nil(t, err)
+	assert.Len(t, ciphertext, 16)
+
+	ciphertext2, err := Encrypt(block, 4, "1234", pan)
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, ciphertext, ciphertext2, "Format 4 PIN blocks should be randomized")
+
+	panField, _ := buildFormat4PanField(pan)
+	assert.Equal(t, "71234567890123456789000000000000", hex.EncodeToString(panField))
+
+	intermediate := make([]byte, 16)
+	block.Decrypt(intermediate, ciphertext)
+	xorBytes(intermediate, intermediate, panField)
+	block.Decrypt(intermediate, intermediate)
+
+	assert.Equal(t, "441234aaaaaaaaaa", hex.EncodeToString(intermediate[:8]))
+
+	pin, err := Decrypt(block, 4, ciphertext, pan)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1234", pin)
+}
+
+func TestInvalidInput(t *testing.T) {
+	tdes, _ := des.NewTripleDESCipher(testTdesKey)
+	aesBlock, _ := aes.NewCipher(testAesKey)
+
+	_, err := Encrypt(tdes, 0, "123", testPan)
+	assert.Equal(t, ErrInvalidPin, err)
+
+	_, err = Encrypt(tdes, 0, "12a4", testPan)
+	assert.Equal(t, ErrInvalidPin, err)
+
+	_, err = Encrypt(tdes, 0, "1234", "411111")
+	assert.Equal(t, ErrInvalidPan, err)
+
+	_, err = Encrypt(tdes, 2, "1234", testPan)
+	assert.Equal(t, ErrInvalidFormat, err)
+
+	_, err = Encrypt(aesBlock, 0, "1234", testPan)
+	assert.NotNil(t, err, "Format 0 should require a 64 bit cipher")
+
+	_, err = Encrypt(tdes, 4, "1234", testPan)
+	assert.NotNil(t, err, "Format 4 should require a 128 bit cipher")
+}
+
+func TestFormat2(t *testing.T) {
+	block, err := EncodeFormat2("1234")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x24, 0x12, 0x34, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, block)
+
+	_, err = EncodeFormat2("12a4")
+
+	assert.Equal(t, ErrInvalidPin, err)
+}
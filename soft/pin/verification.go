@@ -0,0 +1,136 @@
+package pin
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// DefaultDecimalizationTable maps each hex digit to itself modulo 10
+const DefaultDecimalizationTable = "0123456789012345"
+
+var (
+	ErrInvalidPvki                = errors.New("Invalid PVKI")
+	ErrInvalidValidationData      = errors.New("Invalid validation data")
+	ErrInvalidDecimalizationTable = errors.New("Invalid decimalization table")
+	ErrInvalidOffset              = errors.New("Invalid PIN offset")
+)
+
+// Pvv computes the Visa PIN verification value of the leftmost 4 PIN digits
+// with a double length PVK, block being its TDES cipher.
+func Pvv(block cipher.Block, pan string, pvki int, pin string) (string, error) {
+	if block.BlockSize() != 8 {
+		return "", errors.New("PVVs require a 64 bit block cipher")
+	}
+
+	if err := checkDigits(pan); err != nil || len(pan) < 12 {
+		return "", ErrInvalidPan
+	}
+
+	if pvki < 0 || pvki > 9 {
+		return "", ErrInvalidPvki
+	}
+
+	if err := checkPin(pin); err != nil {
+		return "", err
+	}
+
+	// Transformed security parameter: the 11 rightmost PAN digits excluding
+	// the check digit, the PVKI and the leftmost 4 PIN digits
+	tsp := pan[len(pan)-12:len(pan)-1] + string('0'+byte(pvki)) + pin[:4]
+	data, _ := hex.DecodeString(tsp)
+
+	block.Encrypt(data, data)
+
+	encoded := hex.EncodeToString(data)
+	result := make([]byte, 0, 4)
+
+	for i := 0; i < len(encoded) && len(result) < 4; i++ {
+		if encoded[i] <= '9' {
+			result = append(result, encoded[i])
+		}
+	}
+
+	for i := 0; i < len(encoded) && len(result) < 4; i++ {
+		if encoded[i] > '9' {
+			result = append(result, encoded[i]-'a'+'0')
+		}
+	}
+
+	return string(result), nil
+}
+
+// Ibm3624NaturalPin computes the IBM 3624 natural PIN of the given length
+// by enciphering the validation data, up to 16 hex digits right padded with
+// F, and decimalizing the result. An empty table stands for the default
+// one.
+func Ibm3624NaturalPin(block cipher.Block, validationData, table string, length int) (string, error) {
+	if block.BlockSize() != 8 {
+		return "", errors.New("IBM 3624 PINs require a 64 bit block cipher")
+	}
+
+	if len(validationData) == 0 || len(validationData) > 16 {
+		return "", ErrInvalidValidationData
+	}
+
+	if table == "" {
+		table = DefaultDecimalizationTable
+	}
+
+	if len(table) != 16 || checkDigits(table) != nil {
+		return "", ErrInvalidDecimalizationTable
+	}
+
+	if length < MinPinLength || length > 16 {
+		return "", ErrInvalidPin
+	}
+
+	data, err := hex.DecodeString(validationData + strings.Repeat("F", 16-len(validationData)))
+
+	if err != nil {
+		return "", ErrInvalidValidationData
+	}
+
+	block.Encrypt(data, data)
+
+	encoded := hex.EncodeToString(data)
+	result := make([]byte, length)
+
+	for i := range result {
+		result[i] = table[strings.IndexByte("0123456789abcdef", encoded[i])]
+	}
+
+	return string(result), nil
+}
+
+// Ibm3624Offset returns the offset turning the natural PIN into the leftmost
+// digits of pin, digit by digit modulo 10.
+func Ibm3624Offset(natural, pin string) (string, error) {
+	if err := checkPin(pin); err != nil || len(pin) < len(natural) {
+		return "", ErrInvalidPin
+	}
+
+	offset := make([]byte, len(natural))
+
+	for i := range offset {
+		offset[i] = '0' + (pin[i]-natural[i]+10)%10
+	}
+
+	return string(offset), nil
+}
+
+// Ibm3624Pin adds the offset to the natural PIN, digit by digit modulo 10.
+func Ibm3624Pin(natural, offset string) (string, error) {
+	if len(offset) != len(natural) || checkDigits(offset) != nil {
+		return "", ErrInvalidOffset
+	}
+
+	pin := make([]byte, len(natural))
+
+	for i := range pin {
+		pin[i] = '0' + (natural[i]-'0'+offset[i]-'0')%10
+	}
+
+	return string(pin), nil
+}
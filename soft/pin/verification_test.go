@@ -0,0 +1,65 @@
+package pin
+
+import (
+	"crypto/des"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPvv(t *testing.T) {
+	pvk, _ := hex.DecodeString("0123456789ABCDEFFEDCBA98765432100123456789ABCDEF")
+	block, _ := des.NewTripleDESCipher(pvk)
+
+	// Expected values computed outside Go, with OpenSSL's des-ede3
+	pvv, err := Pvv(block, "4123456789012345", 1, "1234")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1894", pvv)
+
+	other, err := Pvv(block, "4123456789012345", 1, "123456")
+
+	assert.Nil(t, err)
+	assert.Equal(t, pvv, other, "Only the 4 leftmost PIN digits are used")
+
+	_, err = Pvv(block, "4123456789012345", 10, "1234")
+	assert.Equal(t, ErrInvalidPvki, err)
+
+	_, err = Pvv(block, "41234567890", 1, "1234")
+	assert.Equal(t, ErrInvalidPan, err)
+}
+
+func TestIbm3624(t *testing.T) {
+	pvk, _ := hex.DecodeString("0123456789ABCDEF")
+	block, _ := des.NewCipher(pvk)
+
+	// Expected values computed outside Go, with OpenSSL's des-ede3
+	natural, err := Ibm3624NaturalPin(block, "4123456789012345", "", 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1760", natural)
+
+	natural, err = Ibm3624NaturalPin(block, "4123456789012345", "9876543210987654", 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "8239", natural)
+
+	offset, err := Ibm3624Offset("1760", "9191")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "8431", offset)
+
+	pin, err := Ibm3624Pin("1760", offset)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "9191", pin)
+
+	_, err = Ibm3624NaturalPin(block, "4123456789012345", "012345678901234", 4)
+	assert.Equal(t, ErrInvalidDecimalizationTable, err)
+
+	_, err = Ibm3624NaturalPin(block, "41234567890123456", "", 4)
+	assert.Equal(t, ErrInvalidValidationData, err)
+
+	_, err = Ibm3624Pin("1760", "12a4")
+	assert.Equal(t, ErrInvalidOffset, err)
+}
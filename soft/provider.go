@@ -15,7 +15,8 @@ import (
 )
 
 type Provider struct {
-	db Database
+	db       Database
+	counters *DukptCounterTracker
 }
 
 func createSoft(u *url.URL) (cryptokit.Provider, error) {
@@ -92,7 +93,7 @@ func New(path string, key []byte) (*Provider, error) {
 }
 
 func NewWithBolt(path string, key []byte) (*Provider, error) {
-	db, err := newBoltDatabase(path, key)
+	db, err := NewBoltDatabase(path, key)
 
 	if err != nil {
 		return nil, err
@@ -102,7 +103,7 @@ func NewWithBolt(path string, key []byte) (*Provider, error) {
 }
 
 func NewWithVault(address, token, base string) (*Provider, error) {
-	db, err := newVaultDatabase(address, token, base)
+	db, err := NewVaultDatabase(address, token, base)
 
 	if err != nil {
 		return nil, err
@@ -112,13 +113,78 @@ func NewWithVault(address, token, base string) (*Provider, error) {
 }
 
 func NewWithDatabase(db Database) (*Provider, error) {
+	return NewWithOptions(db, Options{})
+}
+
+// Options enables optional features of the provider
+type Options struct {
+	// DukptCounters tracks the KSN counters of DUKPT devices, which requires
+	// a database implementing CounterStore
+	DukptCounters *DukptCounterTracker
+}
+
+func NewWithOptions(db Database, options Options) (*Provider, error) {
+	if options.DukptCounters != nil {
+		if _, ok := db.(CounterStore); !ok {
+			return nil, errors.New("The database can't store DUKPT counters")
+		}
+	}
+
 	return &Provider{
-		db: db,
+		db:       db,
+		counters: options.DukptCounters,
 	}, nil
 }
 
+// NewBoltDatabase opens a bolt database whose records are encrypted under
+// key, to be given to NewWithOptions
+func NewBoltDatabase(path string, key []byte) (Database, error) {
+	db, err := newBoltDatabase(path, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func NewVaultDatabase(address, token, base string) (Database, error) {
+	db, err := newVaultDatabase(address, token, base)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
 func (p *Provider) OpenSession() (cryptokit.Session, error) {
-	return &Session{db: p.db}, nil
+	return &Session{db: p.db, counters: p.counters}, nil
+}
+
+// RouteBdk makes DUKPT operations given a nil key use the key identified by
+// id for KSNs with the given key set identifier, as decoded by the KSN
+// layout, or for AES KSNs with the given BDK ID. Routes are kept in the
+// database, which must implement RouteStore, so every provider opened on it
+// shares them.
+func (p *Provider) RouteBdk(ksi []byte, id string) error {
+	routes, ok := p.db.(RouteStore)
+
+	if !ok {
+		return errors.New("The database can't store BDK routes")
+	}
+
+	return routes.SaveRoute(hex.EncodeToString(ksi), id)
+}
+
+func (p *Provider) UnrouteBdk(ksi []byte) error {
+	routes, ok := p.db.(RouteStore)
+
+	if !ok {
+		return errors.New("The database can't store BDK routes")
+	}
+
+	return routes.RemoveRoute(hex.EncodeToString(ksi))
 }
 
 func (p *Provider) Close() error {
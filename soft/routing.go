@@ -0,0 +1,81 @@
+package soft
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/dukpt"
+)
+
+// resolveKey returns key, or when it is nil the BDK routed to the key set
+// identifier of the KSN used by mech
+func (s *Session) resolveKey(mech cryptokit.Mechanism, key cryptokit.Key) (cryptokit.Key, error) {
+	if key != nil {
+		return key, nil
+	}
+
+	d, ok := getDukptMechanism(mech)
+
+	if !ok {
+		return nil, errors.New("A key is required for this mechanism")
+	}
+
+	ksi, err := getKsi(d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	routes, ok := s.db.(RouteStore)
+
+	if !ok {
+		return nil, errors.New("The database can't store BDK routes")
+	}
+
+	id, found, err := routes.FindRoute(hex.EncodeToString(ksi))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, errors.New("No BDK is routed for the KSN")
+	}
+
+	bdk, found, err := s.FindKey(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, errors.New("The routed BDK wasn't found")
+	}
+
+	return bdk, nil
+}
+
+// getKsi returns the key set identifier of a TDES KSN, or the BDK ID of an
+// AES KSN
+func getKsi(mech cryptokit.Dukpt) ([]byte, error) {
+	if mech.Algorithm == cryptokit.DukptAes {
+		if len(mech.Ksn) != dukpt.AesKsnLength {
+			return nil, errors.New("Invalid KSN length")
+		}
+
+		return mech.Ksn[:4], nil
+	}
+
+	layout, err := getKsnLayout(mech.Algorithm, mech.Layout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mech.Ksn) != dukpt.KsnLength {
+		return nil, errors.New("Invalid KSN length")
+	}
+
+	return layout.Decode(mech.Ksn).Ksi, nil
+}
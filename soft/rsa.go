@@ -0,0 +1,71 @@
+package soft
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+
+	"github.com/pagarme/cryptokit"
+)
+
+// generateRsaKey returns the PKCS #8 encoding of a new RSA private key whose
+// modulus is Length bytes long, or of the DER encoded key given to FixedKey
+func generateRsaKey(mech cryptokit.Mechanism, attributes cryptokit.KeyAttributes) ([]byte, error) {
+	var key *rsa.PrivateKey
+
+	switch v := mech.(type) {
+	case cryptokit.FixedKey:
+		parsed, err := parseRsaKey(v.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key = parsed
+	case cryptokit.Random:
+		generated, err := rsa.GenerateKey(rand.Reader, int(attributes.Length)*8)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key = generated
+	default:
+		return nil, errors.New("Unsupported mechanism")
+	}
+
+	if uint(key.Size()) != attributes.Length {
+		return nil, errors.New("Invalid key size")
+	}
+
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+func getRsaPrivateKey(key *Key) (*rsa.PrivateKey, error) {
+	if key.typ != cryptokit.RsaKey {
+		return nil, errors.New("Invalid key type")
+	}
+
+	return parseRsaKey(key.data)
+}
+
+func parseRsaKey(data []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("Invalid RSA private key")
+	}
+
+	return key, nil
+}
@@ -2,13 +2,17 @@ package soft
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"github.com/pagarme/cryptokit"
-	"github.com/pagarme/cryptokit/soft/dukpt"
+	"github.com/pagarme/cryptokit/soft/emv"
+	"github.com/pagarme/cryptokit/soft/mac"
 )
 
 type Session struct {
-	db Database
+	db       Database
+	counters *DukptCounterTracker
 }
 
 func (s *Session) ListKeys() ([]string, error) {
@@ -30,29 +34,124 @@ func (s *Session) FindKey(id string) (cryptokit.Key, bool, error) {
 }
 
 func (s *Session) Encrypt(mech cryptokit.Mechanism, key cryptokit.Key, in []byte) ([]byte, error) {
-	if key.Attributes().Capabilities&cryptokit.Encrypt == 0 {
-		return nil, errors.New("Key can't be used for encryption")
+	key, err := s.resolveKey(mech, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCapability(mech, key, cryptokit.Encrypt); err != nil {
+		return nil, err
 	}
 
 	return s.encryptionCore(mech, key, in, true)
 }
 
 func (s *Session) Decrypt(mech cryptokit.Mechanism, key cryptokit.Key, in []byte) ([]byte, error) {
-	if key.Attributes().Capabilities&cryptokit.Decrypt == 0 {
-		return nil, errors.New("Key can't be used for decryption")
+	key, err := s.resolveKey(mech, key)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return s.encryptionCore(mech, key, in, false)
+	if err := s.counters.check(s.db, mech); err != nil {
+		return nil, err
+	}
+
+	out, err := s.decrypt(mech, key, in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.counters.record(s.db, mech); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *Session) Sign(mech cryptokit.Mechanism, key cryptokit.Key, in []byte) ([]byte, error) {
+	if err := checkCapability(mech, key, cryptokit.Sign); err != nil {
+		return nil, err
+	}
+
+	return s.signatureCore(mech, key, in)
 }
 
-func (s *Session) Translate(mech cryptokit.Mechanism, inKey cryptokit.Key, in []byte, outKey cryptokit.Key) ([]byte, error) {
-	data, err := s.Decrypt(mech, inKey, in)
+func (s *Session) Verify(mech cryptokit.Mechanism, key cryptokit.Key, in, signature []byte) (bool, error) {
+	if err := checkCapability(mech, key, cryptokit.Verify); err != nil {
+		return false, err
+	}
+
+	expected, err := s.signatureCore(mech, key, in)
+
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare(expected, signature) == 1, nil
+}
+
+func (s *Session) Translate(inMech cryptokit.Mechanism, inKey cryptokit.Key, in []byte, outMech cryptokit.Mechanism, outKey cryptokit.Key) ([]byte, error) {
+	inKey, err := s.resolveKey(inMech, inKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outKey, err = s.resolveKey(outMech, outKey)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return s.Encrypt(mech, outKey, data)
+	if err := s.counters.check(s.db, inMech); err != nil {
+		return nil, err
+	}
+
+	out, err := s.translate(inMech, inKey, in, outMech, outKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.counters.record(s.db, inMech); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *Session) translate(inMech cryptokit.Mechanism, inKey cryptokit.Key, in []byte, outMech cryptokit.Mechanism, outKey cryptokit.Key) ([]byte, error) {
+	v, ok := inMech.(cryptokit.PinBlock)
+
+	if !ok {
+		data, err := s.decrypt(inMech, inKey, in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.Encrypt(outMech, outKey, data)
+	}
+
+	switch o := outMech.(type) {
+	case cryptokit.PinBlock:
+		return translatePinBlock(v, inKey, in, o, outKey)
+	case cryptokit.EmvPinChange:
+		return translateEmvPinChange(v, inKey, in, o, outKey)
+	}
+
+	return nil, errors.New("PIN blocks can only be translated to PIN blocks or PIN change data")
+}
+
+func (s *Session) decrypt(mech cryptokit.Mechanism, key cryptokit.Key, in []byte) ([]byte, error) {
+	if err := checkCapability(mech, key, cryptokit.Decrypt); err != nil {
+		return nil, err
+	}
+
+	return s.encryptionCore(mech, key, in, false)
 }
 
 func (s *Session) Wrap(mech cryptokit.Mechanism, kek, key cryptokit.Key) ([]byte, error) {
@@ -60,6 +159,17 @@ func (s *Session) Wrap(mech cryptokit.Mechanism, kek, key cryptokit.Key) ([]byte
 		return nil, errors.New("Key can't be used for wrapping")
 	}
 
+	if err := checkExportable(key.(*Key)); err != nil {
+		return nil, err
+	}
+
+	switch v := mech.(type) {
+	case cryptokit.Tr31:
+		return wrapTr31(v, kek, key.(*Key))
+	case cryptokit.Tr34:
+		return wrapTr34(v, kek, key.(*Key))
+	}
+
 	return s.encryptionCore(mech, kek, key.(*Key).data, true)
 }
 
@@ -68,6 +178,20 @@ func (s *Session) Unwrap(mech cryptokit.Mechanism, kek cryptokit.Key, key []byte
 		return nil, errors.New("Key can't be used for unwrapping")
 	}
 
+	if err := checkMetadata(attributes); err != nil {
+		return nil, err
+	}
+
+	if v, ok := mech.(cryptokit.Tr31); ok {
+		attributes, data, err := unwrapTr31(v, kek, key, attributes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.createKey(attributes, data)
+	}
+
 	if err := s.checkConsistency(attributes); err != nil {
 		return nil, err
 	}
@@ -86,6 +210,20 @@ func (s *Session) Generate(mech cryptokit.Mechanism, attributes cryptokit.KeyAtt
 		return nil, err
 	}
 
+	if err := checkMetadata(attributes); err != nil {
+		return nil, err
+	}
+
+	if attributes.Type == cryptokit.RsaKey {
+		data, err := generateRsaKey(mech, attributes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.createKey(attributes, data)
+	}
+
 	data := make([]byte, attributes.Length)
 
 	switch v := mech.(type) {
@@ -113,11 +251,48 @@ func (s *Session) Derive(mech cryptokit.Mechanism, key cryptokit.Key, attributes
 		return nil, err
 	}
 
+	if err := checkMetadata(attributes); err != nil {
+		return nil, err
+	}
+
 	skey := key.(*Key)
 
 	switch v := mech.(type) {
 	case cryptokit.Dukpt:
-		d, err := dukpt.DerivePekFromBdk(skey.data, v.Ksn)
+		if err := s.counters.check(s.db, v); err != nil {
+			return nil, err
+		}
+
+		d, err := deriveDukptKey(v, skey, attributes.Type, attributes.Length)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.counters.record(s.db, v); err != nil {
+			return nil, err
+		}
+
+		data = d
+	case cryptokit.DukptIpek:
+		d, ksn, err := deriveDukptIpek(v, skey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data = d
+		attributes.Metadata = withMetadata(attributes.Metadata, cryptokit.DukptInitialKsnMetadata, hex.EncodeToString(ksn))
+	case cryptokit.EmvIccMasterKey, cryptokit.EmvSessionKey:
+		d, err := deriveEmvKey(v, skey, attributes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data = d
+	case cryptokit.KeyDiversification:
+		d, err := deriveDiversifiedKey(v, skey, attributes)
 
 		if err != nil {
 			return nil, err
@@ -144,10 +319,50 @@ func (s *Session) Hash(mech cryptokit.Mechanism, in []byte) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
+func (s *Session) KeyCheckValue(key cryptokit.Key) ([]byte, error) {
+	impl, err := getKeyImplementation(key.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	block := make([]byte, impl.BlockSize())
+
+	if key.Type() == cryptokit.AesKey {
+		return mac.Cmac(impl, block)[:5], nil
+	}
+
+	impl.Encrypt(block, block)
+
+	return block[:3], nil
+}
+
 func (s *Session) Close() error {
 	return nil
 }
 
+var capabilityErrors = map[cryptokit.KeyCapability]string{
+	cryptokit.Encrypt: "Key can't be used for encryption",
+	cryptokit.Decrypt: "Key can't be used for decryption",
+	cryptokit.Derive:  "Key can't be used for derivation",
+	cryptokit.Sign:    "Key can't be used for signing",
+	cryptokit.Verify:  "Key can't be used for verification",
+}
+
+// Mechanisms deriving their working key only require the base key to allow
+// derivation
+func checkCapability(mech cryptokit.Mechanism, key cryptokit.Key, capability cryptokit.KeyCapability) error {
+	if derivesKey(mech) {
+		capability = cryptokit.Derive
+	}
+
+	if key.Attributes().Capabilities&capability == 0 {
+		return errors.New(capabilityErrors[capability])
+	}
+
+	return nil
+}
+
 func (s *Session) checkConsistency(a cryptokit.KeyAttributes) error {
 	if a.Length <= 0 {
 		return errors.New("Invalid key size")
@@ -169,6 +384,10 @@ func (s *Session) createKey(a cryptokit.KeyAttributes, data []byte) (*Key, error
 }
 
 func (s *Session) encryptionCore(mech cryptokit.Mechanism, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
+	if usesDukptPinKey(mech) {
+		return nil, errors.New("DUKPT PIN keys can only be used with PIN blocks")
+	}
+
 	switch v := mech.(type) {
 	case cryptokit.BlockCipher:
 		return processBlockCipher(v, key, in, encrypt)
@@ -176,6 +395,55 @@ func (s *Session) encryptionCore(mech cryptokit.Mechanism, key cryptokit.Key, in
 		return processAead(v, key, in, encrypt)
 	case cryptokit.Hmac:
 		return processHmac(v, key, in, encrypt)
+	case cryptokit.PinBlock:
+		return processPinBlock(v, key, in, encrypt)
+	case cryptokit.EmvSecureMessagingEncryption:
+		return processEmvSecureMessaging(v, key, in, encrypt)
+	case cryptokit.KeyVariant:
+		return processKeyVariant(v, key, in, encrypt)
+	case cryptokit.Ff1, cryptokit.Ff31:
+		return processFpe(v, key, in, encrypt)
+	case cryptokit.DukptData:
+		underlying, err := getDukptDataMechanism(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.encryptionCore(underlying, key, in, encrypt)
+	}
+
+	return nil, errors.New("Unknown mechanism")
+}
+
+func (s *Session) signatureCore(mech cryptokit.Mechanism, key cryptokit.Key, in []byte) ([]byte, error) {
+	switch v := mech.(type) {
+	case cryptokit.Hmac:
+		return processHmac(v, key, in, true)
+	case cryptokit.CardVerificationValue:
+		return processCardVerificationValue(v, key)
+	case cryptokit.Dcvv:
+		return processDcvv(v, key)
+	case cryptokit.Cvc3:
+		return processCvc3(v, key, in)
+	case cryptokit.Cavv:
+		return processCavv(v, key)
+	case cryptokit.Aav:
+		return processAav(v, key, in)
+	case cryptokit.Pvv:
+		return processPvv(v, key, in)
+	case cryptokit.Ibm3624:
+		return processIbm3624(v, key, in)
+	case cryptokit.EmvCryptogram:
+		return emv.Mac(key.(*Key).data, in, emv.Padding(v.Padding))
+	case cryptokit.EmvArpc:
+		return processEmvArpc(v, key)
+	case cryptokit.EmvSecureMessagingMac:
+		return processEmvSecureMessagingMac(v, key, in)
+	case cryptokit.RetailMac:
+		return processRetailMac(v, key, in)
+	case cryptokit.Cmac:
+		return processCmac(v, key, in)
 	}
 
 	return nil, errors.New("Unknown mechanism")
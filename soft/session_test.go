@@ -1,11 +1,23 @@
 package soft
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/pin"
+	"github.com/pagarme/cryptokit/soft/tdes"
+	"github.com/pagarme/cryptokit/soft/tr31"
+	"github.com/pagarme/cryptokit/soft/tr34"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"os"
 	"testing"
+	"time"
 )
 
 var wrongKey = []byte{1, 2, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 95, 16, 17, 18, 19, 255, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31}
@@ -447,3 +459,1981 @@ func TestWrapUnwrap(t *testing.T) {
 
 	assert.Equal(t, keyData, keyData2, "Plaintext must be equal to original plaintext")
 }
+
+func TestPinBlockTranslation(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	zpk, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		ID:           "Zpk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	aesKey, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		ID:           "AesZpk",
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	format0 := cryptokit.PinBlock{
+		Underlying: cryptokit.Tdes{},
+		Format:     0,
+		Pan:        "4111111111111111",
+	}
+
+	format4 := cryptokit.PinBlock{
+		Underlying: cryptokit.Aes{},
+		Format:     4,
+		Pan:        "4111111111111111",
+	}
+
+	pinBlock, err := s.Encrypt(format0, zpk, []byte("1234"))
+
+	assert.Nil(t, err, "An error during encryption")
+	assert.Len(t, pinBlock, 8)
+
+	_, err = s.Decrypt(format0, zpk, pinBlock)
+
+	assert.NotNil(t, err, "PIN blocks shouldn't be decrypted")
+
+	translated, err := s.Translate(format0, zpk, pinBlock, format4, aesKey)
+
+	assert.Nil(t, err, "An error during translation")
+	assert.Len(t, translated, 16)
+
+	aesKeyData, _ := aesKey.Extract()
+	block, _ := aes.NewCipher(aesKeyData)
+	clearPin, err := pin.Decrypt(block, 4, translated, "4111111111111111")
+
+	assert.Nil(t, err, "Translated PIN block should be valid")
+	assert.Equal(t, "1234", clearPin)
+
+	roundtrip, err := s.Translate(format4, aesKey, translated, format0, zpk)
+
+	assert.Nil(t, err, "An error during translation")
+	assert.Len(t, roundtrip, 8)
+
+	_, err = s.Translate(format0, zpk, []byte{0, 1, 2, 3, 4, 5, 6, 7}, format0, zpk)
+
+	assert.NotNil(t, err, "Translating an invalid PIN block should fail")
+}
+
+var testBdk = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10}
+var testKsn = []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x08}
+var testPek = []byte{0x27, 0xf6, 0x6d, 0x52, 0x44, 0xff, 0x62, 0x1e, 0xaa, 0x6f, 0x61, 0x20, 0xed, 0xeb, 0x42, 0x7f}
+
+func TestDukptPinTranslation(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	zpk, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		ID:           "Zpk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	pekBlock, _ := tdes.NewCipher(testPek)
+	pinBlock, _ := pin.Encrypt(pekBlock, 0, "1234", "4111111111111111")
+
+	translated, err := s.Translate(cryptokit.PinBlock{
+		Underlying: cryptokit.Dukpt{Ksn: testKsn},
+		Format:     0,
+		Pan:        "4111111111111111",
+	}, bdk, pinBlock, cryptokit.PinBlock{
+		Underlying: cryptokit.Tdes{},
+		Format:     0,
+		Pan:        "4111111111111111",
+	}, zpk)
+
+	assert.Nil(t, err, "An error during translation")
+
+	zpkData, _ := zpk.Extract()
+	zpkBlock, _ := tdes.NewCipher(zpkData)
+	clearPin, err := pin.Decrypt(zpkBlock, 0, translated, "4111111111111111")
+
+	assert.Nil(t, err, "Translated PIN block should be valid")
+	assert.Equal(t, "1234", clearPin)
+
+	_, err = s.Translate(cryptokit.Ecb{
+		Underlying: cryptokit.Dukpt{Ksn: testKsn, Underlying: cryptokit.Tdes{}},
+	}, bdk, pinBlock, cryptokit.Ecb{
+		Underlying: cryptokit.Tdes{},
+	}, zpk)
+
+	assert.NotNil(t, err, "PIN keys should only translate PIN blocks")
+
+	_, err = s.Decrypt(cryptokit.Ecb{
+		Underlying: cryptokit.Tdes{},
+	}, bdk, pinBlock)
+
+	assert.NotNil(t, err, "The BDK shouldn't be usable for decryption")
+}
+
+func TestDukptVariantDerivation(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	ksn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x01}
+
+	attributes := cryptokit.KeyAttributes{
+		ID:           "WorkingKey",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	}
+
+	mac, err := s.Derive(cryptokit.Dukpt{
+		Ksn:     ksn,
+		Variant: cryptokit.DukptMacRequestVariant,
+	}, bdk, attributes)
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	macData, _ := mac.Extract()
+
+	assert.Equal(t, "042666b4918430a368de9628d03984c9", hex.EncodeToString(macData))
+
+	data, err := s.Derive(cryptokit.Dukpt{
+		Ksn:     ksn,
+		Variant: cryptokit.DukptDataRequestVariant,
+	}, bdk, attributes)
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	dataData, _ := data.Extract()
+
+	assert.Equal(t, "448d3f076d8304036a55a3d7e0055a78", hex.EncodeToString(dataData))
+}
+
+func TestAesDukpt(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdkData, _ := hex.DecodeString("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+	ksn, _ := hex.DecodeString("123456789012345600000001")
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: bdkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "AesBdk",
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	pek, err := s.Derive(cryptokit.Dukpt{
+		Ksn:       ksn,
+		Algorithm: cryptokit.DukptAes,
+	}, bdk, cryptokit.KeyAttributes{
+		ID:           "Pek",
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	pekData, _ := pek.Extract()
+
+	assert.Equal(t, "af8cb133a78f8dc2d1359f18527593fb", hex.EncodeToString(pekData))
+
+	plaintext := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	ciphertext, err := s.Encrypt(cryptokit.Cbc{
+		Underlying: cryptokit.Aes{},
+	}, pek, plaintext)
+
+	assert.Nil(t, err, "An error during encryption")
+
+	_, err = s.Decrypt(cryptokit.Cbc{
+		Underlying: cryptokit.Dukpt{Ksn: ksn, Algorithm: cryptokit.DukptAes},
+	}, bdk, ciphertext)
+
+	assert.NotNil(t, err, "PIN keys shouldn't decrypt raw data")
+
+	dataKey, err := s.Derive(cryptokit.Dukpt{
+		Ksn:       ksn,
+		Algorithm: cryptokit.DukptAes,
+		Variant:   cryptokit.DukptDataRequestVariant,
+	}, bdk, cryptokit.KeyAttributes{
+		ID:           "DataKey",
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	ciphertext, err = s.Encrypt(cryptokit.Cbc{
+		Underlying: cryptokit.Aes{},
+	}, dataKey, plaintext)
+
+	assert.Nil(t, err, "An error during encryption")
+
+	plaintext2, err := s.Decrypt(cryptokit.DukptData{
+		Ksn:       ksn,
+		Algorithm: cryptokit.DukptAes,
+	}, bdk, ciphertext)
+
+	assert.Nil(t, err, "An error during decryption")
+	assert.Equal(t, plaintext, plaintext2, "Plaintext must be equal to original plaintext")
+
+	tdesPek, err := s.Derive(cryptokit.Dukpt{
+		Ksn:       ksn,
+		Algorithm: cryptokit.DukptAes,
+	}, bdk, cryptokit.KeyAttributes{
+		ID:           "TdesPek",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	tdesPekData, _ := tdesPek.Extract()
+
+	assert.Len(t, tdesPekData, 16)
+	assert.NotEqual(t, pekData, tdesPekData, "Key type should be bound to the derivation")
+}
+
+func TestDukptIpekDerivation(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	_, err = s.Derive(cryptokit.DukptIpek{
+		Ksn: testKsn,
+	}, bdk, cryptokit.KeyAttributes{
+		ID:           "Ipek",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    true,
+		Extractable:  true,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the IPEK")
+
+	ipek, found, err := s.FindKey("Ipek")
+
+	assert.Nil(t, err, "An error ocurred finding the key")
+	assert.True(t, found, "The key wasn't found")
+	assert.Equal(t, "ffff9876543210e00000", ipek.Attributes().Metadata[cryptokit.DukptInitialKsnMetadata])
+
+	ipekData, _ := ipek.Extract()
+
+	assert.Equal(t, "6ac292faa1315b4d858ab3a3d7d5933a", hex.EncodeToString(ipekData))
+
+	attributes := cryptokit.KeyAttributes{
+		ID:           "Pek",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	}
+
+	pek, err := s.Derive(cryptokit.Dukpt{Ksn: testKsn}, ipek, attributes)
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	pekData, _ := pek.Extract()
+
+	assert.Equal(t, testPek, pekData, "Derived PEK should be correct")
+
+	otherKsn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x11, 0xE0, 0x00, 0x08}
+
+	_, err = s.Derive(cryptokit.Dukpt{Ksn: otherKsn}, ipek, attributes)
+
+	assert.NotNil(t, err, "Initial keys shouldn't derive keys for other devices")
+
+	_, err = s.Derive(cryptokit.DukptIpek{Ksn: testKsn}, ipek, attributes)
+
+	assert.NotNil(t, err, "Initial keys shouldn't derive other initial keys")
+
+	plaintext, err := s.Decrypt(cryptokit.DukptData{Ksn: testKsn}, ipek, make([]byte, 8))
+
+	assert.Nil(t, err, "An error during decryption")
+	assert.Len(t, plaintext, 8)
+
+	forged := cryptokit.KeyAttributes{
+		ID:           "Forged",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Derive,
+		Metadata: map[string]string{
+			cryptokit.DukptInitialKsnMetadata: "ffff9876543210e00000",
+		},
+	}
+
+	_, err = s.Generate(cryptokit.FixedKey{Key: testBdk}, forged)
+
+	assert.NotNil(t, err, "Callers shouldn't set the initial KSN")
+
+	_, err = s.Derive(cryptokit.Dukpt{Ksn: testKsn}, bdk, forged)
+
+	assert.NotNil(t, err, "Callers shouldn't set the initial KSN")
+}
+
+func TestDukptKsnLayout(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	layout := cryptokit.DukptKsnLayout{
+		KsiLength:      9,
+		DeviceIdLength: 6,
+		CounterBits:    20,
+	}
+
+	// The 20th counter bit of the default layout belongs to the device ID
+	ksn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xF0, 0x00, 0x08}
+
+	ipek, err := s.Derive(cryptokit.DukptIpek{
+		Ksn:    ksn,
+		Layout: layout,
+	}, bdk, cryptokit.KeyAttributes{
+		ID:           "Ipek",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the IPEK")
+	assert.Equal(t, "ffff9876543210f00000", ipek.Attributes().Metadata[cryptokit.DukptInitialKsnMetadata])
+
+	attributes := cryptokit.KeyAttributes{
+		ID:           "Pek",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	}
+
+	fromIpek, err := s.Derive(cryptokit.Dukpt{Ksn: ksn, Layout: layout}, ipek, attributes)
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	fromBdk, err := s.Derive(cryptokit.Dukpt{Ksn: ksn, Layout: layout}, bdk, attributes)
+
+	assert.Nil(t, err, "An error ocurred deriving the key")
+
+	fromIpekData, _ := fromIpek.Extract()
+	fromBdkData, _ := fromBdk.Extract()
+
+	assert.Equal(t, fromBdkData, fromIpekData)
+
+	_, err = s.Derive(cryptokit.Dukpt{Ksn: ksn}, ipek, attributes)
+
+	assert.NotNil(t, err, "The default layout should place the KSN in another device")
+
+	_, err = s.Derive(cryptokit.Dukpt{
+		Ksn:       make([]byte, 12),
+		Algorithm: cryptokit.DukptAes,
+		Layout:    layout,
+	}, bdk, attributes)
+
+	assert.NotNil(t, err, "AES DUKPT KSNs have a fixed layout")
+}
+
+func TestDukptData(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	ksn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x01}
+	dataKey, _ := hex.DecodeString("448d3f076d8304036a55a3d7e0055a78")
+	plaintext := []byte("%B5452300551227189^HOGAN/PAUL^08")
+
+	block, _ := tdes.NewCipher(dataKey)
+	ciphertext := make([]byte, len(plaintext))
+
+	cipher.NewCBCEncrypter(block, make([]byte, 8)).CryptBlocks(ciphertext, plaintext)
+
+	result, err := s.Decrypt(cryptokit.DukptData{
+		Ksn:        ksn,
+		Underlying: cryptokit.Cbc{Underlying: cryptokit.Tdes{}},
+	}, bdk, ciphertext)
+
+	assert.Nil(t, err, "An error during decryption")
+	assert.Equal(t, plaintext, result)
+
+	result, err = s.Encrypt(cryptokit.DukptData{Ksn: ksn}, bdk, plaintext)
+
+	assert.Nil(t, err, "An error during encryption")
+	assert.Equal(t, ciphertext, result)
+
+	result, err = s.Encrypt(cryptokit.DukptData{Ksn: ksn, Response: true}, bdk, plaintext)
+
+	assert.Nil(t, err, "An error during encryption")
+	assert.NotEqual(t, ciphertext, result, "Responses should use their own key")
+
+	_, err = s.Decrypt(cryptokit.DukptData{
+		Ksn:        ksn,
+		Underlying: cryptokit.Hmac{Underlying: cryptokit.Sha256{}},
+	}, bdk, ciphertext)
+
+	assert.NotNil(t, err, "Only block cipher modes should be accepted")
+}
+
+func TestBdkRouting(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	_, err = s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    true,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	zpk, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		ID:           "Zpk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	pek, _ := tdes.NewCipher(testPek)
+	pinBlock, _ := pin.Encrypt(pek, 0, "1234", "4012345678909")
+
+	inMech := cryptokit.PinBlock{
+		Underlying: cryptokit.Dukpt{Ksn: testKsn},
+		Format:     0,
+		Pan:        "4012345678909",
+	}
+
+	outMech := cryptokit.PinBlock{
+		Underlying: cryptokit.Tdes{},
+		Format:     0,
+		Pan:        "4012345678909",
+	}
+
+	_, err = s.Translate(inMech, nil, pinBlock, outMech, zpk)
+
+	assert.NotNil(t, err, "No BDK should be routed yet")
+
+	err = p.RouteBdk([]byte{0xFF, 0xFF, 0x98, 0x76, 0x54}, "Bdk")
+
+	assert.Nil(t, err, "An error ocurred routing the BDK")
+
+	translated, err := s.Translate(inMech, nil, pinBlock, outMech, zpk)
+
+	assert.Nil(t, err, "An error ocurred translating the PIN block")
+
+	bdk, found, err := s.FindKey("Bdk")
+
+	assert.Nil(t, err, "An error ocurred finding the key")
+	assert.True(t, found, "The key wasn't found")
+
+	expected, err := s.Translate(inMech, bdk, pinBlock, outMech, zpk)
+
+	assert.Nil(t, err, "An error ocurred translating the PIN block")
+	assert.Equal(t, expected, translated)
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: testKsn}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "An error during decryption")
+
+	_, err = s.Decrypt(cryptokit.Cbc{Underlying: cryptokit.Tdes{}}, nil, make([]byte, 8))
+
+	assert.NotNil(t, err, "Only DUKPT mechanisms can be routed")
+
+	s.Close()
+	p.Close()
+
+	// Routes must survive the provider
+	p, err = New("testdb.db", testKey)
+	s, err = p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: testKsn}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "An error during decryption")
+
+	err = p.UnrouteBdk([]byte{0xFF, 0xFF, 0x98, 0x76, 0x54})
+
+	assert.Nil(t, err, "An error ocurred removing the route")
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: testKsn}, nil, make([]byte, 8))
+
+	assert.NotNil(t, err, "The route should have been removed")
+}
+
+func TestDukptCounterTracking(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	var events []DukptCounterEvent
+
+	tracker := &DukptCounterTracker{
+		ExhaustionWarning: 1000,
+		Notify: func(event DukptCounterEvent) {
+			events = append(events, event)
+		},
+	}
+
+	db, err := NewBoltDatabase("testdb.db", testKey)
+
+	assert.Nil(t, err, "An error ocurred opening the database")
+
+	p, err := NewWithOptions(db, Options{DukptCounters: tracker})
+
+	assert.Nil(t, err, "An error ocurred creating the provider")
+
+	s, err := p.OpenSession()
+
+	_, err = s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    true,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	err = p.RouteBdk([]byte{0xFF, 0xFF, 0x98, 0x76, 0x54}, "Bdk")
+
+	assert.Nil(t, err, "An error ocurred routing the BDK")
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: testKsn}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "An error during decryption")
+	assert.Len(t, events, 0)
+
+	_, err = s.Encrypt(cryptokit.DukptData{Ksn: testKsn, Response: true}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "Responses shouldn't be tracked")
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: testKsn}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "Replays should only be flagged")
+	assert.Equal(t, []DukptCounterEvent{{
+		Kind:     DukptCounterReplayed,
+		Device:   []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x00},
+		Counter:  8,
+		Previous: 8,
+	}}, events)
+
+	s.Close()
+	p.Close()
+
+	// Counters must survive the provider
+	tracker.Reject = true
+	events = nil
+
+	db, err = NewBoltDatabase("testdb.db", testKey)
+	p, err = NewWithOptions(db, Options{DukptCounters: tracker})
+
+	assert.Nil(t, err, "An error ocurred creating the provider")
+
+	s, err = p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	ksn := []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x07}
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: ksn}, nil, make([]byte, 8))
+
+	assert.Equal(t, ErrKsnReplayed, err, "Regressed counters should be rejected")
+	assert.Len(t, events, 1)
+
+	// Counters are checked before anything is decrypted
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: ksn}, nil, make([]byte, 3))
+
+	assert.Equal(t, ErrKsnReplayed, err, "Regressed counters should be rejected")
+
+	bdk, _, err := s.FindKey("Bdk")
+
+	assert.Nil(t, err, "An error ocurred finding the key")
+
+	_, err = s.Derive(cryptokit.Dukpt{Ksn: ksn}, bdk, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Encrypt,
+	})
+
+	assert.Equal(t, ErrKsnReplayed, err, "Derivations should be tracked")
+	assert.Len(t, events, 3)
+
+	_, err = s.Derive(cryptokit.Dukpt{Ksn: ksn, Variant: cryptokit.DukptDataResponseVariant}, bdk, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Encrypt,
+	})
+
+	assert.Nil(t, err, "Response keys shouldn't be tracked")
+	assert.Len(t, events, 3)
+
+	// Failed operations don't use their KSN up
+	ksn = []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xE0, 0x00, 0x10}
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: ksn}, nil, make([]byte, 3))
+
+	assert.NotNil(t, err, "Invalid ciphertexts shouldn't be decrypted")
+	assert.NotEqual(t, ErrKsnReplayed, err)
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: ksn}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "The KSN should still be accepted")
+	assert.Len(t, events, 3)
+
+	events = nil
+
+	ksn = []byte{0xFF, 0xFF, 0x98, 0x76, 0x54, 0x32, 0x10, 0xFF, 0xF8, 0x00}
+
+	_, err = s.Decrypt(cryptokit.DukptData{Ksn: ksn}, nil, make([]byte, 8))
+
+	assert.Nil(t, err, "An error during decryption")
+	assert.Len(t, events, 1)
+	assert.Equal(t, DukptCounterExhausting, events[0].Kind)
+	assert.Equal(t, uint64(0x1FF800), events[0].Counter)
+}
+
+func TestCardVerificationValue(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	cvkData, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	cvk, err := s.Generate(cryptokit.FixedKey{
+		Key: cvkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "Cvk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	mech := cryptokit.CardVerificationValue{
+		Pan:         "4123456789012345",
+		Expiry:      "8701",
+		ServiceCode: "101",
+	}
+
+	cvv, err := s.Sign(mech, cvk, nil)
+
+	assert.Nil(t, err, "An error ocurred generating the CVV")
+	assert.Equal(t, "561", string(cvv))
+
+	ok, err := s.Verify(mech, cvk, nil, []byte("561"))
+
+	assert.Nil(t, err, "An error ocurred verifying the CVV")
+	assert.True(t, ok, "The CVV should be valid")
+
+	ok, err = s.Verify(mech, cvk, nil, []byte("562"))
+
+	assert.Nil(t, err, "An error ocurred verifying the CVV")
+	assert.False(t, ok, "The CVV shouldn't be valid")
+
+	mech.Type = cryptokit.Cvv2
+	cvv2, err := s.Sign(mech, cvk, nil)
+
+	mech.ServiceCode = "000"
+	mech.Type = cryptokit.Cvv
+
+	expected, err := s.Sign(mech, cvk, nil)
+
+	assert.Nil(t, err, "An error ocurred generating the CVV2")
+	assert.Equal(t, expected, cvv2, "CVV2 should ignore the service code")
+
+	_, err = s.Encrypt(mech, cvk, nil)
+
+	assert.NotNil(t, err, "The CVK shouldn't be usable for encryption")
+}
+
+func TestThreeDSecure(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	cavvKeyData, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	cavvKey, err := s.Generate(cryptokit.FixedKey{
+		Key: cavvKeyData,
+	}, cryptokit.KeyAttributes{
+		ID:           "CavvKey",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	cavvMech := cryptokit.Cavv{
+		Pan:              "4123456789012345",
+		Atn:              "1234567890128701",
+		ResultsCode:      "1",
+		SecondFactorCode: "01",
+	}
+
+	cavv, err := s.Sign(cavvMech, cavvKey, nil)
+
+	assert.Nil(t, err, "An error ocurred generating the CAVV")
+	assert.Equal(t, "561", string(cavv))
+
+	ok, err := s.Verify(cavvMech, cavvKey, nil, []byte("562"))
+
+	assert.Nil(t, err, "An error ocurred verifying the CAVV")
+	assert.False(t, ok, "The CAVV shouldn't be valid")
+
+	aavKeyData, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233")
+	aavData, _ := hex.DecodeString("8c0102030405060708090a0b0c0d0e")
+
+	aavKey, err := s.Generate(cryptokit.FixedKey{
+		Key: aavKeyData,
+	}, cryptokit.KeyAttributes{
+		ID:           "AavKey",
+		Type:         cryptokit.RawKey,
+		Length:       20,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Verify,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	aavMech := cryptokit.Aav{
+		Pan: "5412345678901234",
+	}
+
+	mac, _ := hex.DecodeString("c5c928f08e")
+
+	ok, err = s.Verify(aavMech, aavKey, aavData, mac)
+
+	assert.Nil(t, err, "An error ocurred verifying the AAV")
+	assert.True(t, ok, "The AAV should be valid")
+
+	aavMech.Underlying = cryptokit.Sha256{}
+
+	ok, err = s.Verify(aavMech, aavKey, aavData, mac)
+
+	assert.Nil(t, err, "An error ocurred verifying the AAV")
+	assert.False(t, ok, "The AAV shouldn't be valid under another hash")
+
+	_, err = s.Sign(aavMech, aavKey, aavData)
+
+	assert.NotNil(t, err, "The key shouldn't be usable for signing")
+}
+
+func TestPinVerification(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	bdk, err := s.Generate(cryptokit.FixedKey{
+		Key: testBdk,
+	}, cryptokit.KeyAttributes{
+		ID:           "Bdk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	pvkData, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	pvk, err := s.Generate(cryptokit.FixedKey{
+		Key: pvkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "Pvk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	ibmPvk, err := s.Generate(cryptokit.FixedKey{
+		Key: pvkData[:8],
+	}, cryptokit.KeyAttributes{
+		ID:           "IbmPvk",
+		Type:         cryptokit.DesKey,
+		Length:       8,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	pek, _ := tdes.NewCipher(testPek)
+	pinBlock, _ := pin.Encrypt(pek, 0, "1234", "4123456789012345")
+
+	pinBlockMech := cryptokit.PinBlock{
+		Underlying: cryptokit.Dukpt{Ksn: testKsn},
+		Format:     0,
+		Pan:        "4123456789012345",
+	}
+
+	pvv := cryptokit.Pvv{
+		PinBlock: pinBlockMech,
+		PinKey:   bdk,
+		Pvki:     1,
+	}
+
+	value, err := s.Sign(pvv, pvk, pinBlock)
+
+	assert.Nil(t, err, "An error ocurred generating the PVV")
+	assert.Equal(t, "1894", string(value))
+
+	ok, err := s.Verify(pvv, pvk, pinBlock, []byte("1894"))
+
+	assert.Nil(t, err, "An error ocurred verifying the PIN")
+	assert.True(t, ok, "The PIN should be valid")
+
+	wrongPinBlock, _ := pin.Encrypt(pek, 0, "4321", "4123456789012345")
+
+	ok, err = s.Verify(pvv, pvk, wrongPinBlock, []byte("1894"))
+
+	assert.Nil(t, err, "An error ocurred verifying the PIN")
+	assert.False(t, ok, "The PIN shouldn't be valid")
+
+	ibm := cryptokit.Ibm3624{
+		PinBlock:       pinBlockMech,
+		PinKey:         bdk,
+		ValidationData: "4123456789012345",
+	}
+
+	offset, err := s.Sign(ibm, ibmPvk, pinBlock)
+
+	assert.Nil(t, err, "An error ocurred generating the offset")
+
+	// The natural PIN is 1760
+	assert.Equal(t, "0574", string(offset))
+
+	ok, err = s.Verify(ibm, ibmPvk, wrongPinBlock, offset)
+
+	assert.Nil(t, err, "An error ocurred verifying the PIN")
+	assert.False(t, ok, "The PIN shouldn't be valid")
+
+	naturalPinBlock, _ := pin.Encrypt(pek, 0, "1760", "4123456789012345")
+
+	ok, err = s.Verify(ibm, ibmPvk, naturalPinBlock, []byte("0000"))
+
+	assert.Nil(t, err, "An error ocurred verifying the PIN")
+	assert.True(t, ok, "The natural PIN should be valid")
+
+	_, err = s.Sign(pvv, bdk, pinBlock)
+
+	assert.NotNil(t, err, "The BDK shouldn't be usable as a PVK")
+}
+
+func TestEmvCryptograms(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	imkData, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	imk, err := s.Generate(cryptokit.FixedKey{
+		Key: imkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "ImkAc",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	mk, err := s.Derive(cryptokit.EmvIccMasterKey{
+		Pan:               "5413330089010434",
+		PanSequenceNumber: "01",
+	}, imk, cryptokit.KeyAttributes{
+		ID:           "IccMkAc",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the ICC master key")
+
+	sk, err := s.Derive(cryptokit.EmvSessionKey{
+		Atc: []byte{0x00, 0x1C},
+	}, mk, cryptokit.KeyAttributes{
+		ID:           "SkAc",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the session key")
+
+	skData, _ := sk.Extract()
+
+	assert.Equal(t, "c9a56f198477a6945a48f04eba5154be", hex.EncodeToString(skData))
+
+	transactionData, _ := hex.DecodeString("0000000010000000000000000710000000000007101302050030901B6A3C00005503A4A082")
+	arqc, _ := hex.DecodeString("e64a5c4008503cc4")
+
+	ok, err := s.Verify(cryptokit.EmvCryptogram{}, sk, transactionData, arqc)
+
+	assert.Nil(t, err, "An error ocurred verifying the ARQC")
+	assert.True(t, ok, "The ARQC should be valid")
+
+	transactionData[0] = 1
+
+	ok, err = s.Verify(cryptokit.EmvCryptogram{}, sk, transactionData, arqc)
+
+	assert.Nil(t, err, "An error ocurred verifying the ARQC")
+	assert.False(t, ok, "The ARQC shouldn't be valid")
+
+	arpc, err := s.Sign(cryptokit.EmvArpc{
+		Arqc: arqc,
+		Arc:  []byte("00"),
+	}, sk, nil)
+
+	assert.Nil(t, err, "An error ocurred generating the ARPC")
+	assert.Equal(t, "1a106f506d05f6cb", hex.EncodeToString(arpc))
+
+	arpc, err = s.Sign(cryptokit.EmvArpc{
+		Arqc:   arqc,
+		Method: 2,
+		Csu:    []byte{0x00, 0x00, 0x00, 0x00},
+	}, sk, nil)
+
+	assert.Nil(t, err, "An error ocurred generating the ARPC")
+	assert.Equal(t, "0c22779d", hex.EncodeToString(arpc))
+
+	_, err = s.Sign(cryptokit.EmvCryptogram{}, mk, transactionData)
+
+	assert.NotNil(t, err, "The ICC master key shouldn't be usable for MACs")
+}
+
+func TestEmvSecureMessaging(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	mkData, _ := hex.DecodeString("020bc88351bff2e686e951fdfdea1649")
+	arqc, _ := hex.DecodeString("e64a5c4008503cc4")
+
+	mk, err := s.Generate(cryptokit.FixedKey{
+		Key: mkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "IccMkSm",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	smi, err := s.Derive(cryptokit.EmvSessionKey{Ac: arqc}, mk, cryptokit.KeyAttributes{
+		ID:           "Smi",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.SignVerify,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the integrity key")
+
+	smc, err := s.Derive(cryptokit.EmvSessionKey{Ac: arqc}, mk, cryptokit.KeyAttributes{
+		ID:           "Smc",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.Encrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the confidentiality key")
+
+	script, _ := hex.DecodeString("8424000008001ce64a5c4008503cc4")
+
+	mac, err := s.Sign(cryptokit.EmvSecureMessagingMac{Length: 4}, smi, script)
+
+	assert.Nil(t, err, "An error ocurred generating the MAC")
+	assert.Len(t, mac, 4)
+
+	ok, err := s.Verify(cryptokit.EmvSecureMessagingMac{Length: 4}, smi, script, mac)
+
+	assert.Nil(t, err, "An error ocurred verifying the MAC")
+	assert.True(t, ok, "The MAC should be valid")
+
+	zpk, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		ID:           "Zpk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	zpkData, _ := zpk.Extract()
+	zpkCipher, _ := tdes.NewCipher(zpkData)
+	pinBlock, _ := pin.Encrypt(zpkCipher, 0, "4321", "5413330089010434")
+
+	pinData, err := s.Translate(cryptokit.PinBlock{
+		Underlying: cryptokit.Tdes{},
+		Format:     0,
+		Pan:        "5413330089010434",
+	}, zpk, pinBlock, cryptokit.EmvPinChange{}, smc)
+
+	assert.Nil(t, err, "An error ocurred translating the PIN block")
+	assert.Len(t, pinData, 16)
+
+	smcData, _ := smc.Extract()
+	smcCipher, _ := tdes.NewCipher(smcData)
+	plaintext := make([]byte, 16)
+
+	cipher.NewCBCDecrypter(smcCipher, make([]byte, 8)).CryptBlocks(plaintext, pinData)
+
+	assert.Equal(t, "08244321ffffffffff80000000000000", hex.EncodeToString(plaintext))
+
+	_, err = s.Decrypt(cryptokit.EmvSecureMessagingEncryption{}, smc, pinData)
+
+	assert.NotNil(t, err, "The confidentiality key shouldn't decrypt")
+
+	smcDecrypt, err := s.Derive(cryptokit.EmvSessionKey{Ac: arqc}, mk, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred deriving the confidentiality key")
+
+	_, err = s.Translate(cryptokit.PinBlock{
+		Underlying: cryptokit.Tdes{},
+		Format:     0,
+		Pan:        "5413330089010434",
+	}, zpk, pinBlock, cryptokit.EmvPinChange{}, smcDecrypt)
+
+	assert.NotNil(t, err, "Keys allowed to decrypt shouldn't receive PINs")
+}
+
+func TestDynamicCardVerificationValues(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	keyData, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	key, err := s.Generate(cryptokit.FixedKey{
+		Key: keyData,
+	}, cryptokit.KeyAttributes{
+		ID:           "CardKey",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Verify,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	dcvv := cryptokit.Dcvv{
+		Pan:    "4123456789012345",
+		Expiry: "8701",
+		Atc:    []byte{0x00, 0x1C},
+	}
+
+	ok, err := s.Verify(dcvv, key, nil, []byte("676"))
+
+	assert.Nil(t, err, "An error ocurred verifying the dCVV")
+	assert.True(t, ok, "The dCVV should be valid")
+
+	dcvv.Atc = []byte{0x00, 0x1D}
+
+	ok, err = s.Verify(dcvv, key, nil, []byte("676"))
+
+	assert.Nil(t, err, "An error ocurred verifying the dCVV")
+	assert.False(t, ok, "The dCVV shouldn't be valid for another ATC")
+
+	track, _ := hex.DecodeString("5413330089010434d25122010000000000000f")
+
+	cvc3 := cryptokit.Cvc3{
+		UnpredictableNumber: []byte{0x00, 0x00, 0x12, 0x34},
+		Atc:                 []byte{0x00, 0x1C},
+	}
+
+	ok, err = s.Verify(cvc3, key, track, []byte("863"))
+
+	assert.Nil(t, err, "An error ocurred verifying the CVC3")
+	assert.True(t, ok, "The CVC3 should be valid")
+
+	_, err = s.Sign(cvc3, key, track)
+
+	assert.NotNil(t, err, "The key shouldn't be usable for generation")
+}
+
+func TestTr31(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	kbpk, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		Type:         cryptokit.AesKey,
+		Length:       32,
+		Capabilities: cryptokit.Wrap | cryptokit.Unwrap,
+	})
+
+	assert.Nil(t, err)
+
+	bdk, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	ksn, _ := hex.DecodeString("ffff9876543210e00000")
+
+	base, err := s.Generate(cryptokit.FixedKey{Key: bdk}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err)
+
+	ipek, err := s.Derive(cryptokit.DukptIpek{Ksn: ksn}, base, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Extractable:  true,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err)
+
+	block, err := s.Wrap(cryptokit.Tr31{KeyUsage: "B1", IncludeKcv: true}, kbpk, ipek)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "D", string(block[:1]))
+	assert.Equal(t, "B1TX00E", string(block[5:12]))
+
+	imported, err := s.Unwrap(cryptokit.Tr31{KeyUsage: "B1"}, kbpk, block, cryptokit.KeyAttributes{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, cryptokit.KeyType(cryptokit.TdesKey), imported.Type())
+	assert.Equal(t, uint(16), imported.Length())
+	assert.Equal(t, cryptokit.KeyCapability(cryptokit.Derive), imported.Attributes().Capabilities)
+	assert.Equal(t, "B1", imported.Attributes().Metadata[cryptokit.Tr31KeyUsageMetadata])
+	assert.Equal(t, "ffff9876543210e00000", imported.Attributes().Metadata[cryptokit.DukptInitialKsnMetadata])
+
+	// The imported key keeps deriving the device's keys
+	ksn[9] = 1
+
+	pek, err := s.Derive(cryptokit.Dukpt{Ksn: ksn}, imported, cryptokit.KeyAttributes{
+		Type:        cryptokit.TdesKey,
+		Length:      16,
+		Extractable: true,
+	})
+
+	assert.Nil(t, err)
+
+	pekData, _ := pek.Extract()
+
+	assert.Equal(t, "042666b49184cf5c68de9628d0397b36", hex.EncodeToString(pekData))
+
+	// Header restrictions are enforced on import
+	_, err = s.Unwrap(cryptokit.Tr31{KeyUsage: "P0"}, kbpk, block, cryptokit.KeyAttributes{})
+
+	assert.NotNil(t, err)
+
+	_, err = s.Unwrap(cryptokit.Tr31{}, kbpk, block, cryptokit.KeyAttributes{
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.NotNil(t, err)
+
+	_, err = s.Unwrap(cryptokit.Tr31{}, kbpk, block, cryptokit.KeyAttributes{
+		Type: cryptokit.AesKey,
+	})
+
+	assert.NotNil(t, err)
+
+	block, err = s.Wrap(cryptokit.Tr31{KeyUsage: "B1", NonExportable: true}, kbpk, ipek)
+
+	assert.Nil(t, err)
+
+	_, err = s.Unwrap(cryptokit.Tr31{}, kbpk, block, cryptokit.KeyAttributes{Extractable: true})
+
+	assert.NotNil(t, err)
+
+	// Keys from non-exportable blocks can't leave the provider again
+	imported, err = s.Unwrap(cryptokit.Tr31{}, kbpk, block, cryptokit.KeyAttributes{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "N", imported.Attributes().Metadata[cryptokit.Tr31ExportabilityMetadata])
+
+	_, err = s.Wrap(cryptokit.Tr31{KeyUsage: "B1"}, kbpk, imported)
+
+	assert.NotNil(t, err)
+
+	_, err = s.Wrap(cryptokit.Ecb{Underlying: cryptokit.Aes{}}, kbpk, imported)
+
+	assert.NotNil(t, err)
+
+	// Version B and C key blocks require a TDES key block protection key
+	_, err = s.Wrap(cryptokit.Tr31{KeyUsage: "B1", Version: "B"}, kbpk, ipek)
+
+	assert.NotNil(t, err)
+
+	tdesKbpk, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       24,
+		Capabilities: cryptokit.Wrap | cryptokit.Unwrap,
+	})
+
+	assert.Nil(t, err)
+
+	for _, version := range []string{"B", "C"} {
+		block, err = s.Wrap(cryptokit.Tr31{KeyUsage: "B1", Version: version, IncludeKcv: true}, tdesKbpk, ipek)
+
+		assert.Nil(t, err)
+		assert.Equal(t, version, string(block[:1]))
+
+		imported, err = s.Unwrap(cryptokit.Tr31{Version: version}, tdesKbpk, block, cryptokit.KeyAttributes{Extractable: true})
+
+		assert.Nil(t, err)
+
+		importedData, _ := imported.Extract()
+		ipekData, _ := ipek.Extract()
+
+		assert.Equal(t, ipekData, importedData)
+	}
+
+	// Key blocks must use the binding method expected for the KBPK
+	_, err = s.Unwrap(cryptokit.Tr31{}, tdesKbpk, block, cryptokit.KeyAttributes{})
+
+	assert.NotNil(t, err, "Version C blocks shouldn't be accepted in place of B")
+
+	tdesData, _ := hex.DecodeString("89e88cf7931444f334bd7547fc3f380c")
+
+	fixedKbpk, err := s.Generate(cryptokit.FixedKey{Key: tdesData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Unwrap,
+	})
+
+	assert.Nil(t, err)
+
+	block, err = tr31.Wrap(tdesData, tr31.Header{
+		Version:       tr31.VersionD,
+		KeyUsage:      "B1",
+		Algorithm:     'T',
+		ModeOfUse:     'X',
+		KeyVersion:    "00",
+		Exportability: 'E',
+	}, tdesData)
+
+	assert.Nil(t, err)
+
+	_, err = s.Unwrap(cryptokit.Tr31{}, fixedKbpk, block, cryptokit.KeyAttributes{})
+
+	assert.NotNil(t, err, "TDES KBPKs shouldn't open version D blocks")
+}
+
+func TestTr34(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	newCertificate := func(name string, key *rsa.PrivateKey, issuer *x509.Certificate, issuerKey *rsa.PrivateKey) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  issuer == nil,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		}
+
+		if issuer == nil {
+			issuer, issuerKey = template, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+
+		assert.Nil(t, err)
+
+		cert, err := x509.ParseCertificate(der)
+
+		assert.Nil(t, err)
+
+		return cert
+	}
+
+	caKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	kdhKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	deviceKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ca := newCertificate("CA", caKey, nil, nil)
+	kdh := newCertificate("KDH", kdhKey, nil, nil)
+	device := newCertificate("Device", deviceKey, ca, caKey)
+
+	kdhKeyData, _ := x509.MarshalPKCS8PrivateKey(kdhKey)
+
+	kdhHeld, err := s.Generate(cryptokit.FixedKey{Key: kdhKeyData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.RsaKey,
+		Length:       256,
+		Capabilities: cryptokit.Wrap,
+	})
+
+	assert.Nil(t, err)
+
+	bdk, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	ksn, _ := hex.DecodeString("ffff9876543210e00000")
+
+	base, err := s.Generate(cryptokit.FixedKey{Key: bdk}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err)
+
+	// The IPEK is sent without ever being extractable in clear
+	ipek, err := s.Derive(cryptokit.DukptIpek{Ksn: ksn}, base, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err)
+
+	nonce, _ := hex.DecodeString("167eb0e72781e494")
+
+	token, err := s.Wrap(cryptokit.Tr34{
+		DeviceCertificate: device.Raw,
+		CaCertificate:     ca.Raw,
+		KdhCertificate:    kdh.Raw,
+		Nonce:             nonce,
+	}, kdhHeld, ipek)
+
+	assert.Nil(t, err)
+
+	parsed, err := tr34.ParseToken(token, kdh, deviceKey, device)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "6ac292faa1315b4d858ab3a3d7d5933a", hex.EncodeToString(parsed.Key))
+	assert.Equal(t, "A0040B1TX00N0100KS18FFFF9876543210E00000", parsed.Header)
+	assert.Equal(t, nonce, parsed.Nonce)
+
+	// Only RSA keys sign key tokens
+	_, err = s.Wrap(cryptokit.Tr34{
+		DeviceCertificate: device.Raw,
+		KdhCertificate:    kdh.Raw,
+	}, base, ipek)
+
+	assert.NotNil(t, err)
+
+	// Devices must be certified by the CA
+	untrusted := newCertificate("Untrusted", deviceKey, nil, nil)
+
+	_, err = s.Wrap(cryptokit.Tr34{
+		DeviceCertificate: untrusted.Raw,
+		CaCertificate:     ca.Raw,
+		KdhCertificate:    kdh.Raw,
+	}, kdhHeld, ipek)
+
+	assert.NotNil(t, err, "Uncertified devices shouldn't receive keys")
+
+	_, err = s.Wrap(cryptokit.Tr34{
+		DeviceCertificate: device.Raw,
+		KdhCertificate:    kdh.Raw,
+	}, kdhHeld, ipek)
+
+	assert.NotNil(t, err, "A CA should be required")
+
+	// The signing key must be the KDH's
+	_, err = s.Wrap(cryptokit.Tr34{
+		DeviceCertificate: device.Raw,
+		CaCertificate:     ca.Raw,
+		KdhCertificate:    ca.Raw,
+	}, kdhHeld, ipek)
+
+	assert.NotNil(t, err, "The key should match the KDH certificate")
+
+	// RSA keys can be generated by the provider too
+	generated, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		Type:         cryptokit.RsaKey,
+		Length:       128,
+		Capabilities: cryptokit.Wrap,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, cryptokit.KeyType(cryptokit.RsaKey), generated.Type())
+}
+
+func TestKeyVariant(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	kekData, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	zpkData, _ := hex.DecodeString("f039121bec83d26b169bdcd5b22aaf8f")
+
+	kek, err := s.Generate(cryptokit.FixedKey{Key: kekData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Wrap | cryptokit.Unwrap,
+	})
+
+	assert.Nil(t, err)
+
+	zpk, err := s.Generate(cryptokit.FixedKey{Key: zpkData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err)
+
+	// Under a ZMK every class is enciphered the same
+	wrapped, err := s.Wrap(cryptokit.KeyVariant{Class: cryptokit.ZpkClass}, kek, zpk)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "e346b21daeefb41f5ed1be657fe4bbe7", hex.EncodeToString(wrapped))
+
+	tak, err := s.Wrap(cryptokit.KeyVariant{Class: cryptokit.TakClass}, kek, zpk)
+
+	assert.Nil(t, err)
+	assert.Equal(t, wrapped, tak)
+
+	// Under the Thales test LMK, ZPKs go under pair 06-07 with variant 0 and
+	// CVKs under pair 14-15 with variant 4
+	lmks := []struct {
+		Class    cryptokit.KeyClass
+		Lmk      string
+		Expected string
+	}{
+		{cryptokit.ZpkClass, "61616161616161617070707070707070", "3475b748546cf14999252938d44ae11d"},
+		{cryptokit.CvkClass, "e0e0010101010101f1f1010101010101", "5bcd6a9f94b0dd36c7f4e821cf4bc9c5"},
+	}
+
+	for _, l := range lmks {
+		lmkData, _ := hex.DecodeString(l.Lmk)
+
+		lmk, err := s.Generate(cryptokit.FixedKey{Key: lmkData}, cryptokit.KeyAttributes{
+			Type:         cryptokit.TdesKey,
+			Length:       16,
+			Capabilities: cryptokit.Wrap | cryptokit.Unwrap,
+		})
+
+		assert.Nil(t, err)
+
+		encrypted, err := s.Wrap(cryptokit.KeyVariant{Class: l.Class, Lmk: true}, lmk, zpk)
+
+		assert.Nil(t, err)
+		assert.Equal(t, l.Expected, hex.EncodeToString(encrypted))
+	}
+
+	unwrapped, err := s.Unwrap(cryptokit.KeyVariant{Class: cryptokit.ZpkClass}, kek, wrapped, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Extractable:  true,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err)
+
+	unwrappedData, _ := unwrapped.Extract()
+
+	assert.Equal(t, zpkData, unwrappedData)
+}
+
+func TestKeyCheckValue(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	tdesData, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	aesData, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+
+	tdes, err := s.Generate(cryptokit.FixedKey{Key: tdesData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Wrap,
+	})
+
+	assert.Nil(t, err)
+
+	kcv, err := cryptokit.KeyCheckValue(s, tdes)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "08d7b4", hex.EncodeToString(kcv))
+
+	aes, err := s.Generate(cryptokit.FixedKey{Key: aesData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Capabilities: cryptokit.Wrap,
+	})
+
+	assert.Nil(t, err)
+
+	kcv, err = cryptokit.KeyCheckValue(s, aes)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "7ad386c376", hex.EncodeToString(kcv))
+}
+
+func TestWorkingKeyExchange(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	zmkData, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+
+	zmk, err := s.Generate(cryptokit.FixedKey{Key: zmkData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Wrap | cryptokit.Unwrap,
+	})
+
+	assert.Nil(t, err)
+
+	formats := []cryptokit.KeyExchangeFormat{cryptokit.VariantFormat, cryptokit.X917Format, cryptokit.Tr31Format}
+
+	for _, format := range formats {
+		exchanged, err := cryptokit.GenerateWorkingKey(s, cryptokit.ZpkClass, zmk, format, cryptokit.KeyAttributes{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, cryptokit.KeyCapability(cryptokit.EncryptDecrypt), exchanged.Key.Attributes().Capabilities)
+
+		_, err = exchanged.Key.Extract()
+
+		assert.NotNil(t, err, "Working keys shouldn't be extractable by default")
+
+		kcv, _ := cryptokit.KeyCheckValue(s, exchanged.Key)
+
+		assert.Equal(t, kcv, exchanged.Kcv)
+
+		// The counterpart imports the key, checking the KCV
+		imported, err := cryptokit.ImportWorkingKey(s, cryptokit.ZpkClass, zmk, format, exchanged.Encrypted, exchanged.Kcv, cryptokit.KeyAttributes{})
+
+		assert.Nil(t, err)
+
+		importedKcv, _ := cryptokit.KeyCheckValue(s, imported)
+
+		assert.Equal(t, exchanged.Kcv, importedKcv)
+
+		_, err = cryptokit.ImportWorkingKey(s, cryptokit.ZpkClass, zmk, format, exchanged.Encrypted, []byte{0, 0, 0}, cryptokit.KeyAttributes{})
+
+		assert.Equal(t, cryptokit.ErrKcvMismatch, err)
+	}
+
+	// Keys enciphered in another format don't check out
+	exchanged, err := cryptokit.GenerateWorkingKey(s, cryptokit.TakClass, zmk, cryptokit.VariantFormat, cryptokit.KeyAttributes{})
+
+	assert.Nil(t, err)
+
+	_, err = cryptokit.ImportWorkingKey(s, cryptokit.TakClass, zmk, cryptokit.X917Format, exchanged.Encrypted, exchanged.Kcv, cryptokit.KeyAttributes{})
+
+	assert.Equal(t, cryptokit.ErrKcvMismatch, err)
+
+	// Keys that can't be exported aren't left behind
+	unwrapOnly, err := s.Generate(cryptokit.FixedKey{Key: zmkData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.Unwrap,
+	})
+
+	assert.Nil(t, err)
+
+	_, err = cryptokit.GenerateWorkingKey(s, cryptokit.ZpkClass, unwrapOnly, cryptokit.VariantFormat, cryptokit.KeyAttributes{
+		ID:        "Zpk",
+		Permanent: true,
+	})
+
+	assert.NotNil(t, err)
+
+	_, found, err := s.FindKey("Zpk")
+
+	assert.Nil(t, err)
+	assert.False(t, found, "The generated key should be destroyed")
+}
+
+func TestFormatPreservingEncryption(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	keyData, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+
+	key, err := s.Generate(cryptokit.FixedKey{Key: keyData}, cryptokit.KeyAttributes{
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err)
+
+	tweak, _ := hex.DecodeString("39383736353433323130")
+
+	ciphertext, err := s.Encrypt(cryptokit.Ff1{Tweak: tweak}, key, []byte("0123456789"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "6124200773", string(ciphertext))
+
+	plaintext, err := s.Decrypt(cryptokit.Ff1{Tweak: tweak}, key, ciphertext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789", string(plaintext))
+
+	pan := []byte("4111111111111111")
+
+	ciphertext, err = s.Encrypt(cryptokit.Ff31{Tweak: tweak[:7]}, key, pan)
+
+	assert.Nil(t, err)
+	assert.Regexp(t, "^[0-9]{16}$", string(ciphertext))
+
+	plaintext, err = s.Decrypt(cryptokit.Ff31{Tweak: tweak[:7]}, key, ciphertext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, pan, plaintext)
+
+	tdes, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err)
+
+	_, err = s.Encrypt(cryptokit.Ff1{}, tdes, pan)
+
+	assert.NotNil(t, err)
+}
+
+func TestKeyDiversification(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := New("testdb.db", testKey)
+	s, err := p.OpenSession()
+
+	defer p.Close()
+	defer s.Close()
+
+	imkData, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	imk, err := s.Generate(cryptokit.FixedKey{
+		Key: imkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "Imk",
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	attributes := cryptokit.KeyAttributes{
+		Type:         cryptokit.TdesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.SignVerify,
+	}
+
+	for _, option := range []cryptokit.EmvDerivationOption{cryptokit.EmvOptionA, cryptokit.EmvOptionB} {
+		pan := "5413330089010434"
+
+		if option == cryptokit.EmvOptionB {
+			pan = "5413330089010434123"
+		}
+
+		mk, err := s.Derive(cryptokit.KeyDiversification{
+			Method:            cryptokit.EmvDiversification,
+			Pan:               pan,
+			PanSequenceNumber: "01",
+			Option:            option,
+		}, imk, attributes)
+
+		assert.Nil(t, err, "An error ocurred diversifying the key")
+
+		expected, err := s.Derive(cryptokit.EmvIccMasterKey{
+			Pan:               pan,
+			PanSequenceNumber: "01",
+			Option:            option,
+		}, imk, attributes)
+
+		assert.Nil(t, err, "An error ocurred deriving the ICC master key")
+
+		kcv, _ := cryptokit.KeyCheckValue(s, mk)
+		expectedKcv, _ := cryptokit.KeyCheckValue(s, expected)
+
+		assert.Equal(t, expectedKcv, kcv, "EMV diversification should derive the ICC master key")
+	}
+
+	extractable := attributes
+	extractable.Extractable = true
+
+	_, err = s.Derive(cryptokit.EmvIccMasterKey{Pan: "5413330089010434"}, imk, extractable)
+
+	assert.NotNil(t, err, "ICC master keys shouldn't be extractable")
+
+	data, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+
+	mech := cryptokit.KeyDiversification{
+		Method: cryptokit.EcbDiversification,
+		Data:   data,
+	}
+
+	dk, err := s.Derive(mech, imk, attributes)
+
+	assert.Nil(t, err, "An error ocurred diversifying the key")
+
+	kcv, err := cryptokit.KeyCheckValue(s, dk)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "2b7bda", hex.EncodeToString(kcv))
+
+	aesMkData, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+
+	aesMk, err := s.Generate(cryptokit.FixedKey{
+		Key: aesMkData,
+	}, cryptokit.KeyAttributes{
+		ID:           "AesMk",
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Permanent:    false,
+		Extractable:  false,
+		Capabilities: cryptokit.Derive,
+	})
+
+	assert.Nil(t, err, "An error ocurred generating the key")
+
+	dk, err = s.Derive(mech, aesMk, cryptokit.KeyAttributes{
+		Type:         cryptokit.AesKey,
+		Length:       16,
+		Capabilities: cryptokit.EncryptDecrypt,
+	})
+
+	assert.Nil(t, err, "An error ocurred diversifying the key")
+
+	kcv, err = cryptokit.KeyCheckValue(s, dk)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "482a2cc618", hex.EncodeToString(kcv))
+
+	attributes.Extractable = true
+
+	_, err = s.Derive(mech, imk, attributes)
+
+	assert.NotNil(t, err, "Diversified keys shouldn't be extractable")
+
+	attributes.Extractable = false
+	attributes.Length = 24
+
+	_, err = s.Derive(mech, imk, attributes)
+
+	assert.NotNil(t, err, "The data should have the derived key's length")
+}
@@ -0,0 +1,25 @@
+package tdes
+
+import (
+	"crypto/cipher"
+	"crypto/des"
+)
+
+// ExpandKey expands double length keys to the K1-K2-K1 keying option,
+// returning other keys as they are
+func ExpandKey(key []byte) []byte {
+	if len(key) != 16 {
+		return key
+	}
+
+	result := make([]byte, 24)
+	copy(result, key)
+	copy(result[16:], key[:8])
+
+	return result
+}
+
+// NewCipher returns the TDES cipher of a double or triple length key
+func NewCipher(key []byte) (cipher.Block, error) {
+	return des.NewTripleDESCipher(ExpandKey(key))
+}
@@ -0,0 +1,32 @@
+package tdes
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewCipher(t *testing.T) {
+	key, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+
+	block, err := NewCipher(key)
+
+	assert.Nil(t, err)
+
+	out := make([]byte, 8)
+	block.Encrypt(out, out)
+
+	assert.Equal(t, "08d7b4fb629d0885", hex.EncodeToString(out))
+
+	block, err = NewCipher(append(key, key[:8]...))
+
+	assert.Nil(t, err)
+
+	block.Encrypt(out, make([]byte, 8))
+
+	assert.Equal(t, "08d7b4fb629d0885", hex.EncodeToString(out))
+
+	_, err = NewCipher(key[:8])
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,220 @@
+package soft
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/tr31"
+)
+
+var tr31Algorithms = map[cryptokit.KeyType]byte{
+	cryptokit.AesKey:  'A',
+	cryptokit.DesKey:  'D',
+	cryptokit.TdesKey: 'T',
+	cryptokit.RawKey:  'H',
+}
+
+// Capabilities allowed by each TR-31 mode of use, encryption and decryption
+// covering wrapping and unwrapping too
+var tr31ModesOfUse = map[byte]cryptokit.KeyCapability{
+	'B': cryptokit.EncryptDecrypt | cryptokit.Wrap | cryptokit.Unwrap,
+	'E': cryptokit.Encrypt | cryptokit.Wrap,
+	'D': cryptokit.Decrypt | cryptokit.Unwrap,
+	'C': cryptokit.SignVerify,
+	'G': cryptokit.Sign,
+	'V': cryptokit.Verify,
+	'X': cryptokit.Derive,
+	'N': cryptokit.AllCapabilities,
+}
+
+// wrapTr31 exports key enciphered under kbpk, so like other wrapping
+// mechanisms it doesn't require the key to be extractable in clear
+func wrapTr31(mech cryptokit.Tr31, kbpk cryptokit.Key, key *Key) ([]byte, error) {
+	version, err := getTr31Version(mech, kbpk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, ok := tr31Algorithms[key.typ]
+
+	if !ok {
+		return nil, errors.New("Key type can't be exported in TR-31 key blocks")
+	}
+
+	header := tr31.Header{
+		Version:       version,
+		KeyUsage:      mech.KeyUsage,
+		Algorithm:     algorithm,
+		ModeOfUse:     getTr31ModeOfUse(key.capabilities),
+		KeyVersion:    mech.KeyVersion,
+		Exportability: 'E',
+	}
+
+	if header.KeyUsage == "" {
+		header.KeyUsage = key.metadata[cryptokit.Tr31KeyUsageMetadata]
+	}
+
+	if header.KeyUsage == "" {
+		return nil, errors.New("TR-31 key usage required")
+	}
+
+	if header.KeyVersion == "" {
+		header.KeyVersion = "00"
+	}
+
+	if mech.NonExportable {
+		header.Exportability = 'N'
+	}
+
+	if ksn, ok := key.metadata[cryptokit.DukptInitialKsnMetadata]; ok {
+		id := "KS"
+
+		if key.typ == cryptokit.AesKey {
+			id = "IK"
+		}
+
+		header.OptionalBlocks = append(header.OptionalBlocks, tr31.OptionalBlock{ID: id, Data: strings.ToUpper(ksn)})
+	}
+
+	if mech.IncludeKcv {
+		kcv, err := tr31.KeyCheckValue(algorithm, key.data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		header.OptionalBlocks = append(header.OptionalBlocks, tr31.OptionalBlock{ID: "KC", Data: kcv})
+	}
+
+	return tr31.Wrap(kbpk.(*Key).data, header, key.data)
+}
+
+// unwrapTr31 returns the key carried by block along with attributes
+// completed from its header, failing when they conflict with it
+func unwrapTr31(mech cryptokit.Tr31, kbpk cryptokit.Key, block []byte, attributes cryptokit.KeyAttributes) (cryptokit.KeyAttributes, []byte, error) {
+	version, err := getTr31Version(mech, kbpk)
+
+	if err != nil {
+		return attributes, nil, err
+	}
+
+	// The KBPK must not be used with another binding method than its own
+	if len(block) == 0 || block[0] != version {
+		return attributes, nil, errors.New("Unexpected TR-31 key block version")
+	}
+
+	header, data, err := tr31.Unwrap(kbpk.(*Key).data, block)
+
+	if err != nil {
+		return attributes, nil, err
+	}
+
+	if mech.KeyUsage != "" && mech.KeyUsage != header.KeyUsage {
+		return attributes, nil, errors.New("Unexpected TR-31 key usage")
+	}
+
+	typ := cryptokit.KeyType(0)
+
+	for t, a := range tr31Algorithms {
+		if a == header.Algorithm {
+			typ = t
+		}
+	}
+
+	if typ == 0 {
+		return attributes, nil, errors.New("Unsupported TR-31 key algorithm")
+	}
+
+	if attributes.Type == 0 {
+		attributes.Type = typ
+	} else if attributes.Type != typ {
+		return attributes, nil, errors.New("Key type doesn't match the key block")
+	}
+
+	if attributes.Length == 0 {
+		attributes.Length = uint(len(data))
+	} else if attributes.Length != uint(len(data)) {
+		return attributes, nil, errors.New("Key length doesn't match the key block")
+	}
+
+	capabilities, ok := tr31ModesOfUse[header.ModeOfUse]
+
+	if !ok {
+		return attributes, nil, errors.New("Unsupported TR-31 mode of use")
+	}
+
+	if attributes.Capabilities == 0 {
+		attributes.Capabilities = capabilities
+	} else if attributes.Capabilities&^capabilities != 0 {
+		return attributes, nil, errors.New("Key capabilities exceed the key block's mode of use")
+	}
+
+	if attributes.Extractable && header.Exportability != 'E' {
+		return attributes, nil, errors.New("Key block isn't exportable")
+	}
+
+	if kcv, ok := header.Find("KC"); ok {
+		expected, err := tr31.KeyCheckValue(header.Algorithm, data)
+
+		if err != nil {
+			return attributes, nil, err
+		}
+
+		if kcv != expected {
+			return attributes, nil, errors.New("Key check value doesn't match")
+		}
+	}
+
+	attributes.Metadata = withMetadata(attributes.Metadata, cryptokit.Tr31KeyUsageMetadata, header.KeyUsage)
+	attributes.Metadata = withMetadata(attributes.Metadata, cryptokit.Tr31ExportabilityMetadata, string(header.Exportability))
+
+	for _, id := range []string{"KS", "IK"} {
+		if ksn, ok := header.Find(id); ok {
+			if _, err := hex.DecodeString(ksn); err != nil {
+				return attributes, nil, tr31.ErrInvalidKeyBlock
+			}
+
+			attributes.Metadata = withMetadata(attributes.Metadata, cryptokit.DukptInitialKsnMetadata, strings.ToLower(ksn))
+		}
+	}
+
+	return attributes, data, nil
+}
+
+// getTr31Version returns the key block version, checking it can be used
+// with the key block protection key
+func getTr31Version(mech cryptokit.Tr31, kbpk cryptokit.Key) (byte, error) {
+	version := mech.Version
+
+	if version == "" {
+		version = "B"
+
+		if kbpk.Type() == cryptokit.AesKey {
+			version = "D"
+		}
+	}
+
+	switch {
+	case version == "D" && kbpk.Type() == cryptokit.AesKey:
+	case (version == "B" || version == "C") && kbpk.Type() == cryptokit.TdesKey:
+	default:
+		return 0, errors.New("Key block protection key can't be used with this TR-31 version")
+	}
+
+	return version[0], nil
+}
+
+// getTr31ModeOfUse returns the most restrictive mode of use allowing every
+// capability of a key
+func getTr31ModeOfUse(capabilities cryptokit.KeyCapability) byte {
+	for _, mode := range []byte{'X', 'G', 'V', 'C', 'E', 'D', 'B'} {
+		if capabilities&^tr31ModesOfUse[mode] == 0 {
+			return mode
+		}
+	}
+
+	return 'N'
+}
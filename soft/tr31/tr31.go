@@ -0,0 +1,510 @@
+package tr31
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pagarme/cryptokit/soft/mac"
+	"github.com/pagarme/cryptokit/soft/tdes"
+)
+
+const (
+	VersionB = 'B'
+	VersionC = 'C'
+	VersionD = 'D'
+)
+
+const headerLength = 16
+
+var (
+	ErrInvalidKeyBlock = errors.New("Invalid TR-31 key block")
+	ErrInvalidMac      = errors.New("TR-31 key block MAC doesn't match")
+	ErrInvalidVersion  = errors.New("Unsupported TR-31 key block version")
+	ErrInvalidKbpk     = errors.New("Invalid key block protection key")
+)
+
+// Header holds the key block header fields, Algorithm, ModeOfUse and
+// Exportability being single characters. The padding block is handled by
+// Wrap and Unwrap and never shows up in OptionalBlocks.
+type Header struct {
+	Version        byte
+	KeyUsage       string
+	Algorithm      byte
+	ModeOfUse      byte
+	KeyVersion     string
+	Exportability  byte
+	OptionalBlocks []OptionalBlock
+}
+
+type OptionalBlock struct {
+	ID   string
+	Data string
+}
+
+// Find returns the data of the optional block with the given ID
+func (h Header) Find(id string) (string, bool) {
+	for _, b := range h.OptionalBlocks {
+		if b.ID == id {
+			return b.Data, true
+		}
+	}
+
+	return "", false
+}
+
+// Wrap builds the key block of key protected by kbpk, a TDES key for
+// versions B and C and an AES key for version D. The key is padded to the
+// longest key of its algorithm so its length isn't disclosed.
+func Wrap(kbpk []byte, header Header, key []byte) ([]byte, error) {
+	v, err := getVersion(header.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header.KeyUsage) != 2 || len(header.KeyVersion) != 2 {
+		return nil, ErrInvalidKeyBlock
+	}
+
+	optional, count, err := encodeOptionalBlocks(header.OptionalBlocks, v.blockSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	maxLength := 24
+
+	if header.Algorithm == 'A' || header.Algorithm == 'H' {
+		maxLength = 32
+	}
+
+	if len(key) > maxLength {
+		maxLength = len(key)
+	}
+
+	clear := make([]byte, (2+maxLength+v.blockSize-1)/v.blockSize*v.blockSize)
+	binary.BigEndian.PutUint16(clear, uint16(len(key)*8))
+	copy(clear[2:], key)
+
+	if _, err := rand.Read(clear[2+len(key):]); err != nil {
+		return nil, err
+	}
+
+	total := headerLength + len(optional) + 2*len(clear) + 2*v.macLength
+
+	if total > 9999 {
+		return nil, ErrInvalidKeyBlock
+	}
+
+	prefix := formatHeader(header, total, count, optional)
+
+	if len(prefix) != headerLength+len(optional) {
+		return nil, ErrInvalidKeyBlock
+	}
+
+	encrypted, tag, err := v.seal(kbpk, []byte(prefix), clear)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(prefix + strings.ToUpper(hex.EncodeToString(encrypted)+hex.EncodeToString(tag))), nil
+}
+
+// EncodeHeader returns a header on its own, as carried by TR-34 key blocks,
+// its length field holding the header length
+func EncodeHeader(header Header) (string, error) {
+	if len(header.KeyUsage) != 2 || len(header.KeyVersion) != 2 {
+		return "", ErrInvalidKeyBlock
+	}
+
+	optional, count, err := encodeOptionalBlocks(header.OptionalBlocks, 1)
+
+	if err != nil {
+		return "", err
+	}
+
+	return formatHeader(header, headerLength+len(optional), count, optional), nil
+}
+
+// Unwrap authenticates and decrypts a key block, returning its header and
+// the key it carries
+func Unwrap(kbpk []byte, block []byte) (Header, []byte, error) {
+	var header Header
+
+	s := string(block)
+
+	if len(s) < headerLength {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	v, err := getVersion(s[0])
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	total, err := strconv.Atoi(s[1:5])
+
+	if err != nil || total != len(s) {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	count, err := strconv.Atoi(s[12:14])
+
+	if err != nil {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	header = Header{
+		Version:       s[0],
+		KeyUsage:      s[5:7],
+		Algorithm:     s[7],
+		ModeOfUse:     s[8],
+		KeyVersion:    s[9:11],
+		Exportability: s[11],
+	}
+
+	optionalLength, err := decodeOptionalBlocks(&header, s[headerLength:], count)
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	prefix := s[:headerLength+optionalLength]
+	body := s[len(prefix):]
+
+	if len(body) < 2*v.macLength || (len(body)-2*v.macLength)%(2*v.blockSize) != 0 {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	encrypted, err := hex.DecodeString(body[:len(body)-2*v.macLength])
+
+	if err != nil {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	tag, err := hex.DecodeString(body[len(body)-2*v.macLength:])
+
+	if err != nil {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	clear, err := v.open(kbpk, []byte(prefix), encrypted, tag)
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	if len(clear) < 2 {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	length := int(binary.BigEndian.Uint16(clear))
+
+	if length%8 != 0 || length/8 > len(clear)-2 {
+		return header, nil, ErrInvalidKeyBlock
+	}
+
+	return header, clear[2 : 2+length/8], nil
+}
+
+func formatHeader(header Header, length, count int, optional string) string {
+	return fmt.Sprintf("%c%04d%s%c%c%s%c%02d00%s", header.Version, length, header.KeyUsage, header.Algorithm, header.ModeOfUse, header.KeyVersion, header.Exportability, count, optional)
+}
+
+func encodeOptionalBlocks(blocks []OptionalBlock, blockSize int) (string, int, error) {
+	result := ""
+
+	for _, b := range blocks {
+		if len(b.ID) != 2 || b.ID == "PB" || len(b.Data)+4 > 0xFF {
+			return "", 0, ErrInvalidKeyBlock
+		}
+
+		result += fmt.Sprintf("%s%02X%s", b.ID, len(b.Data)+4, b.Data)
+	}
+
+	count := len(blocks)
+
+	if count == 0 {
+		return result, 0, nil
+	}
+
+	// The header must be a multiple of the cipher's block size
+	if rest := (headerLength + len(result)) % blockSize; rest != 0 {
+		padding := blockSize - rest
+
+		if padding < 4 {
+			padding += blockSize
+		}
+
+		result += fmt.Sprintf("PB%02X%s", padding, strings.Repeat("0", padding-4))
+		count++
+	}
+
+	return result, count, nil
+}
+
+func decodeOptionalBlocks(header *Header, s string, count int) (int, error) {
+	offset := 0
+
+	for i := 0; i < count; i++ {
+		if len(s) < offset+4 {
+			return 0, ErrInvalidKeyBlock
+		}
+
+		id := s[offset : offset+2]
+		length, err := strconv.ParseUint(s[offset+2:offset+4], 16, 8)
+
+		if err != nil || length < 4 || len(s) < offset+int(length) {
+			return 0, ErrInvalidKeyBlock
+		}
+
+		if id != "PB" {
+			header.OptionalBlocks = append(header.OptionalBlocks, OptionalBlock{
+				ID:   id,
+				Data: s[offset+4 : offset+int(length)],
+			})
+		}
+
+		offset += int(length)
+	}
+
+	return offset, nil
+}
+
+type version struct {
+	blockSize int
+	macLength int
+	seal      func(kbpk, header, clear []byte) ([]byte, []byte, error)
+	open      func(kbpk, header, encrypted, tag []byte) ([]byte, error)
+}
+
+func getVersion(v byte) (version, error) {
+	switch v {
+	case VersionB:
+		return version{8, 8, sealDerived, openDerived}, nil
+	case VersionC:
+		return version{8, 4, sealVariant, openVariant}, nil
+	case VersionD:
+		return version{16, 16, sealDerived, openDerived}, nil
+	}
+
+	return version{}, ErrInvalidVersion
+}
+
+// Versions B and D derive the encryption and MAC keys from the KBPK with
+// CMAC, authenticate the clear key data and use the MAC as the IV
+func sealDerived(kbpk, header, clear []byte) ([]byte, []byte, error) {
+	kbek, kbmk, err := deriveKeys(kbpk, header[0])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tag := mac.Cmac(kbmk, append(append([]byte{}, header...), clear...))
+	encrypted := make([]byte, len(clear))
+
+	cipher.NewCBCEncrypter(kbek, tag[:kbek.BlockSize()]).CryptBlocks(encrypted, clear)
+
+	return encrypted, tag, nil
+}
+
+func openDerived(kbpk, header, encrypted, tag []byte) ([]byte, error) {
+	kbek, kbmk, err := deriveKeys(kbpk, header[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	clear := make([]byte, len(encrypted))
+
+	cipher.NewCBCDecrypter(kbek, tag[:kbek.BlockSize()]).CryptBlocks(clear, encrypted)
+
+	expected := mac.Cmac(kbmk, append(append([]byte{}, header...), clear...))
+
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return nil, ErrInvalidMac
+	}
+
+	return clear, nil
+}
+
+func deriveKeys(kbpk []byte, v byte) (cipher.Block, cipher.Block, error) {
+	var newCipher func([]byte) (cipher.Block, error)
+	var algorithm uint16
+
+	// 0000 and 0001 stand for 2TDEA and 3TDEA, 0002, 0003 and 0004 for
+	// AES-128, AES-192 and AES-256
+	switch {
+	case v == VersionB && (len(kbpk) == 16 || len(kbpk) == 24):
+		newCipher = tdes.NewCipher
+		algorithm = uint16(len(kbpk)-16) / 8
+	case v == VersionD && (len(kbpk) == 16 || len(kbpk) == 24 || len(kbpk) == 32):
+		newCipher = aes.NewCipher
+		algorithm = uint16(len(kbpk)-16)/8 + 2
+	default:
+		return nil, nil, ErrInvalidKbpk
+	}
+
+	block, err := newCipher(kbpk)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	derive := func(usage uint16) (cipher.Block, error) {
+		data := make([]byte, 8)
+		key := make([]byte, 0, len(kbpk)+block.BlockSize())
+
+		binary.BigEndian.PutUint16(data[1:3], usage)
+		binary.BigEndian.PutUint16(data[4:6], algorithm)
+		binary.BigEndian.PutUint16(data[6:8], uint16(len(kbpk)*8))
+
+		for i := byte(1); len(key) < len(kbpk); i++ {
+			data[0] = i
+			key = append(key, mac.Cmac(block, data)...)
+		}
+
+		return newCipher(key[:len(kbpk)])
+	}
+
+	kbek, err := derive(0)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kbmk, err := derive(1)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return kbek, kbmk, nil
+}
+
+// Version C XORs the KBPK with fixed variants, encrypts using the first
+// bytes of the header as the IV and authenticates the encrypted key data
+// with a TDES CBC-MAC
+func sealVariant(kbpk, header, clear []byte) ([]byte, []byte, error) {
+	kbek, kbmk, err := variantKeys(kbpk)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted := make([]byte, len(clear))
+
+	cipher.NewCBCEncrypter(kbek, header[:8]).CryptBlocks(encrypted, clear)
+
+	return encrypted, variantMac(kbmk, header, encrypted), nil
+}
+
+func openVariant(kbpk, header, encrypted, tag []byte) ([]byte, error) {
+	kbek, kbmk, err := variantKeys(kbpk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(variantMac(kbmk, header, encrypted), tag) != 1 {
+		return nil, ErrInvalidMac
+	}
+
+	clear := make([]byte, len(encrypted))
+
+	cipher.NewCBCDecrypter(kbek, header[:8]).CryptBlocks(clear, encrypted)
+
+	return clear, nil
+}
+
+func variantKeys(kbpk []byte) (cipher.Block, cipher.Block, error) {
+	if len(kbpk) != 16 && len(kbpk) != 24 {
+		return nil, nil, ErrInvalidKbpk
+	}
+
+	kbek := make([]byte, len(kbpk))
+	kbmk := make([]byte, len(kbpk))
+
+	for i, b := range kbpk {
+		kbek[i] = b ^ 0x45
+		kbmk[i] = b ^ 0x4D
+	}
+
+	encryption, err := tdes.NewCipher(kbek)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authentication, err := tdes.NewCipher(kbmk)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encryption, authentication, nil
+}
+
+func variantMac(kbmk cipher.Block, header, encrypted []byte) []byte {
+	data := append(append([]byte{}, header...), encrypted...)
+
+	result := make([]byte, 8)
+
+	for i := 0; i < len(data); i += 8 {
+		for j := 0; j < 8 && i+j < len(data); j++ {
+			result[j] ^= data[i+j]
+		}
+
+		kbmk.Encrypt(result, result)
+	}
+
+	return result[:4]
+}
+
+// KeyCheckValue returns the contents of a KC optional block, the legacy 3
+// byte KCV of TDES and DES keys and the 5 byte CMAC based KCV of AES keys
+func KeyCheckValue(algorithm byte, key []byte) (string, error) {
+	switch algorithm {
+	case 'T', 'D':
+		var block cipher.Block
+		var err error
+
+		if algorithm == 'D' {
+			block, err = des.NewCipher(key)
+		} else {
+			block, err = tdes.NewCipher(key)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		kcv := make([]byte, block.BlockSize())
+		block.Encrypt(kcv, kcv)
+
+		return "00" + strings.ToUpper(hex.EncodeToString(kcv[:3])), nil
+	case 'A':
+		block, err := aes.NewCipher(key)
+
+		if err != nil {
+			return "", err
+		}
+
+		kcv := mac.Cmac(block, make([]byte, block.BlockSize()))
+
+		return "01" + strings.ToUpper(hex.EncodeToString(kcv[:5])), nil
+	}
+
+	return "", ErrInvalidKeyBlock
+}
@@ -0,0 +1,129 @@
+package tr31
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var testTdesKbpk, _ = hex.DecodeString("89e88cf7931444f334bd7547fc3f380c")
+var testAesKbpk, _ = hex.DecodeString("88e1ab2a2e3dd38c1fa039a536500cc8a87ab9d62dc92c01058fa79f44657de6")
+
+// ANSI X9.143 version D example
+func TestUnwrapVersionD(t *testing.T) {
+	block := []byte("D0112P0AE00E0000B82679114F470F540165EDFBF7E250FCEA43F810D215F8D207E2E417C07156A27E8E31DA05F7425509593D03A457DC34")
+
+	header, key, err := Unwrap(testAesKbpk, block)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "3f419e1cb7079442aa37474c2efbf8b8", hex.EncodeToString(key))
+	assert.Equal(t, "P0", header.KeyUsage)
+	assert.Equal(t, byte('A'), header.Algorithm)
+	assert.Equal(t, byte('E'), header.ModeOfUse)
+	assert.Equal(t, byte('E'), header.Exportability)
+}
+
+// TR-31:2018 version B example
+func TestUnwrapVersionB(t *testing.T) {
+	kbpk, _ := hex.DecodeString("dd7515f2bfc17f85ce48f3ca25cb21f6")
+	block := []byte("B0080P0TE00E000094B420079CC80BA3461F86FE26EFC4A3B8E4FA4C5F5341176EED7B727B8A248E")
+
+	header, key, err := Unwrap(kbpk, block)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "3f419e1cb7079442aa37474c2efbf8b8", hex.EncodeToString(key))
+	assert.Equal(t, "P0", header.KeyUsage)
+	assert.Equal(t, byte('T'), header.Algorithm)
+}
+
+// Version C binds keys as the deprecated version A does, so the TR-31:2010
+// version A example checks it
+func TestVariantBinding(t *testing.T) {
+	header := []byte("A0072P0TE00E0000")
+	encrypted, _ := hex.DecodeString("F5161ED902807AF26F1D62263644BD24192FDB3193C73030")
+	tag, _ := hex.DecodeString("1CEE8701")
+
+	clear, err := openVariant(testTdesKbpk, header, encrypted, tag)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "0080f039121bec83d26b169bdcd5b22aaf8f", hex.EncodeToString(clear[:18]))
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	key, _ := hex.DecodeString("f039121bec83d26b169bdcd5b22aaf8f")
+
+	for _, v := range []byte{VersionB, VersionC, VersionD} {
+		kbpk := testTdesKbpk
+		algorithm := byte('T')
+
+		if v == VersionD {
+			kbpk = testAesKbpk
+			algorithm = 'A'
+		}
+
+		header := Header{
+			Version:       v,
+			KeyUsage:      "P0",
+			Algorithm:     algorithm,
+			ModeOfUse:     'E',
+			KeyVersion:    "00",
+			Exportability: 'E',
+			OptionalBlocks: []OptionalBlock{
+				{ID: "KS", Data: "FFFF9876543210E00000"},
+			},
+		}
+
+		block, err := Wrap(kbpk, header, key)
+
+		assert.Nil(t, err)
+		assert.Equal(t, v, block[0])
+
+		unwrapped, data, err := Unwrap(kbpk, block)
+
+		assert.Nil(t, err)
+		assert.Equal(t, key, data)
+		assert.Equal(t, header, unwrapped)
+
+		ks, ok := unwrapped.Find("KS")
+
+		assert.True(t, ok)
+		assert.Equal(t, "FFFF9876543210E00000", ks)
+
+		// Flipping the mode of use must break the MAC
+		tampered := append([]byte{}, block...)
+		tampered[8] = 'D'
+
+		_, _, err = Unwrap(kbpk, tampered)
+
+		assert.Equal(t, ErrInvalidMac, err)
+	}
+}
+
+func TestInvalidKeyBlocks(t *testing.T) {
+	key, _ := hex.DecodeString("f039121bec83d26b169bdcd5b22aaf8f")
+	header := Header{Version: VersionD, KeyUsage: "P0", Algorithm: 'A', ModeOfUse: 'E', KeyVersion: "00", Exportability: 'E'}
+
+	_, err := Wrap(testTdesKbpk[:8], header, key)
+
+	assert.Equal(t, ErrInvalidKbpk, err)
+
+	header.Version = 'A'
+
+	_, err = Wrap(testAesKbpk, header, key)
+
+	assert.Equal(t, ErrInvalidVersion, err)
+
+	_, _, err = Unwrap(testAesKbpk, []byte("D0020P0AE00E0000"))
+
+	assert.Equal(t, ErrInvalidKeyBlock, err)
+
+	// An authenticated block without key data
+	_, kbmk, _ := variantKeys(testTdesKbpk)
+	prefix := []byte("C0024P0TE00E0000")
+	block := append(prefix, strings.ToUpper(hex.EncodeToString(variantMac(kbmk, prefix, nil)))...)
+
+	_, _, err = Unwrap(testTdesKbpk, block)
+
+	assert.Equal(t, ErrInvalidKeyBlock, err)
+}
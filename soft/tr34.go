@@ -0,0 +1,114 @@
+package soft
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"strings"
+
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/tr31"
+	"github.com/pagarme/cryptokit/soft/tr34"
+)
+
+// wrapTr34 builds the key token of key, signed with the key distribution
+// host's private key kdhKey. Like TR-31 key blocks, it doesn't require the
+// key to be extractable in clear.
+func wrapTr34(mech cryptokit.Tr34, kdhKey cryptokit.Key, key *Key) ([]byte, error) {
+	signer, err := getRsaPrivateKey(kdhKey.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	kdh, err := x509.ParseCertificate(mech.KdhCertificate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if public, ok := kdh.PublicKey.(*rsa.PublicKey); !ok || public.N.Cmp(signer.N) != 0 || public.E != signer.E {
+		return nil, errors.New("Key doesn't match the KDH certificate")
+	}
+
+	device, err := verifyTr34Device(mech)
+
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, ok := tr31Algorithms[key.typ]
+
+	if !ok {
+		return nil, errors.New("Key type can't be exported in TR-34 key tokens")
+	}
+
+	header := tr31.Header{
+		Version:       'A',
+		KeyUsage:      mech.KeyUsage,
+		Algorithm:     algorithm,
+		ModeOfUse:     getTr31ModeOfUse(key.capabilities),
+		KeyVersion:    "00",
+		Exportability: 'N',
+	}
+
+	if header.KeyUsage == "" {
+		header.KeyUsage = key.metadata[cryptokit.Tr31KeyUsageMetadata]
+	}
+
+	if header.KeyUsage == "" {
+		header.KeyUsage = "B1"
+	}
+
+	if ksn, ok := key.metadata[cryptokit.DukptInitialKsnMetadata]; ok {
+		id := "KS"
+
+		if key.typ == cryptokit.AesKey {
+			id = "IK"
+		}
+
+		header.OptionalBlocks = append(header.OptionalBlocks, tr31.OptionalBlock{ID: id, Data: strings.ToUpper(ksn)})
+	}
+
+	encoded, err := tr31.EncodeHeader(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tr34.GenerateToken(kdh, signer, device, mech.Nonce, encoded, key.data)
+}
+
+// verifyTr34Device parses the device certificate, which must be issued by
+// the trusted certificate authority so keys only reach genuine devices
+func verifyTr34Device(mech cryptokit.Tr34) (*x509.Certificate, error) {
+	if len(mech.CaCertificate) == 0 {
+		return nil, errors.New("A CA certificate is required to trust the device")
+	}
+
+	ca, err := x509.ParseCertificate(mech.CaCertificate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := x509.ParseCertificate(mech.DeviceCertificate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	_, err = device.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
@@ -0,0 +1,516 @@
+package tr34
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidRandomNonce   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 25, 3}
+	oidSha256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSha256WithRsa = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidRsaesOaep     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMgf1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidDesEde3Cbc    = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	sha256Identifier = pkix.AlgorithmIdentifier{Algorithm: oidSha256, Parameters: asn1.NullRawValue}
+	sha256WithRsa    = pkix.AlgorithmIdentifier{Algorithm: oidSha256WithRsa, Parameters: asn1.NullRawValue}
+)
+
+const (
+	keyBlockVersion      = 1
+	envelopedDataVersion = 0
+	signedDataVersion    = 1
+	signerInfoVersion    = 1
+)
+
+var (
+	ErrInvalidToken       = errors.New("Invalid TR-34 key token")
+	ErrInvalidSignature   = errors.New("TR-34 key token signature doesn't match")
+	ErrUnknownRecipient   = errors.New("TR-34 key token isn't enciphered for this device")
+	ErrUnknownKdh         = errors.New("TR-34 key token wasn't issued by this key distribution host")
+	ErrUnsupportedKeyType = errors.New("TR-34 requires RSA keys")
+)
+
+// Token is the content of a key token, as seen by the receiving device
+type Token struct {
+	Header string
+	Key    []byte
+	Nonce  []byte
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	Sid                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	Rid                    issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0"`
+}
+
+type keyBlock struct {
+	Version         int
+	IdKdh           issuerAndSerialNumber
+	ClearKey        []byte
+	AttributeHeader attribute
+}
+
+type oaepParameters struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+// GenerateToken builds the key token of a two pass TR-34 key transport:
+// the key and its TR-31 style header are enciphered for the device with an
+// ephemeral TDES key transported under RSAES-OAEP, and the result is signed
+// by the key distribution host along with the device's nonce.
+func GenerateToken(kdh *x509.Certificate, kdhKey crypto.Signer, device *x509.Certificate, nonce []byte, header string, key []byte) ([]byte, error) {
+	devicePublic, ok := device.PublicKey.(*rsa.PublicKey)
+
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	if _, ok := kdhKey.Public().(*rsa.PublicKey); !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	headerValue, err := asn1.Marshal([]byte(header))
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := asn1.Marshal(keyBlock{
+		Version:  keyBlockVersion,
+		IdKdh:    getIssuerAndSerialNumber(kdh),
+		ClearKey: key,
+		AttributeHeader: attribute{
+			Type:   oidData,
+			Values: []asn1.RawValue{{FullBytes: headerValue}},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	enveloped, err := envelope(device, devicePublic, block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sign(kdh, kdhKey, enveloped, nonce)
+}
+
+// ParseToken verifies the KDH signature of a key token and deciphers it with
+// the device's private key
+func ParseToken(token []byte, kdh *x509.Certificate, deviceKey *rsa.PrivateKey, device *x509.Certificate) (*Token, error) {
+	kdhPublic, ok := kdh.PublicKey.(*rsa.PublicKey)
+
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	var info contentInfo
+
+	if err := unmarshal(token, &info); err != nil || !info.ContentType.Equal(oidSignedData) || info.Content.Tag != 0 {
+		return nil, ErrInvalidToken
+	}
+
+	var signed signedData
+
+	if err := unmarshal(info.Content.Bytes, &signed); err != nil || len(signed.SignerInfos) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	signer := signed.SignerInfos[0]
+
+	if !signer.Sid.matches(kdh) {
+		return nil, ErrUnknownKdh
+	}
+
+	attributes, err := parseAttributes(signer.SignedAttrs.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var digest, nonce []byte
+
+	if _, err := asn1.Unmarshal(attributes[oidMessageDigest.String()], &digest); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := asn1.Unmarshal(attributes[oidRandomNonce.String()], &nonce); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	content := sha256.Sum256(signed.EncapContentInfo.EContent)
+
+	if subtle.ConstantTimeCompare(content[:], digest) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	signedAttrs := sha256.Sum256(setOf(signer.SignedAttrs.Bytes))
+
+	if err := rsa.VerifyPKCS1v15(kdhPublic, crypto.SHA256, signedAttrs[:], signer.Signature); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	block, err := open(signed.EncapContentInfo.EContent, deviceKey, device)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var kb keyBlock
+
+	if err := unmarshal(block, &kb); err != nil || kb.Version != keyBlockVersion || len(kb.AttributeHeader.Values) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	if !kb.IdKdh.matches(kdh) {
+		return nil, ErrUnknownKdh
+	}
+
+	var header []byte
+
+	if _, err := asn1.Unmarshal(kb.AttributeHeader.Values[0].FullBytes, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &Token{
+		Header: string(header),
+		Key:    kb.ClearKey,
+		Nonce:  nonce,
+	}, nil
+}
+
+func envelope(device *x509.Certificate, public *rsa.PublicKey, content []byte) ([]byte, error) {
+	key := make([]byte, 24)
+	iv := make([]byte, des.BlockSize)
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := des.NewTripleDESCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	padding := des.BlockSize - len(content)%des.BlockSize
+	encrypted := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, public, key, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	oaep, err := getOaepIdentifier()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ivParameter, err := asn1.Marshal(iv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(envelopedData{
+		Version: envelopedDataVersion,
+		RecipientInfos: []keyTransRecipientInfo{{
+			Rid:                    getIssuerAndSerialNumber(device),
+			KeyEncryptionAlgorithm: oaep,
+			EncryptedKey:           encryptedKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidDesEde3Cbc,
+				Parameters: asn1.RawValue{FullBytes: ivParameter},
+			},
+			EncryptedContent: encrypted,
+		},
+	})
+}
+
+func open(enveloped []byte, deviceKey *rsa.PrivateKey, device *x509.Certificate) ([]byte, error) {
+	var data envelopedData
+
+	if err := unmarshal(enveloped, &data); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var recipient *keyTransRecipientInfo
+
+	for i := range data.RecipientInfos {
+		if data.RecipientInfos[i].Rid.matches(device) {
+			recipient = &data.RecipientInfos[i]
+		}
+	}
+
+	if recipient == nil {
+		return nil, ErrUnknownRecipient
+	}
+
+	if !recipient.KeyEncryptionAlgorithm.Algorithm.Equal(oidRsaesOaep) {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, deviceKey, recipient.EncryptedKey, nil)
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	content := data.EncryptedContentInfo
+	var iv []byte
+
+	if !content.ContentEncryptionAlgorithm.Algorithm.Equal(oidDesEde3Cbc) {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := asn1.Unmarshal(content.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != des.BlockSize {
+		return nil, ErrInvalidToken
+	}
+
+	block, err := des.NewTripleDESCipher(key)
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	encrypted := content.EncryptedContent
+
+	if len(encrypted) == 0 || len(encrypted)%des.BlockSize != 0 {
+		return nil, ErrInvalidToken
+	}
+
+	clear := make([]byte, len(encrypted))
+
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(clear, encrypted)
+
+	padding := int(clear[len(clear)-1])
+
+	if padding == 0 || padding > des.BlockSize {
+		return nil, ErrInvalidToken
+	}
+
+	return clear[:len(clear)-padding], nil
+}
+
+func sign(kdh *x509.Certificate, kdhKey crypto.Signer, content, nonce []byte) ([]byte, error) {
+	digest := sha256.Sum256(content)
+
+	attributes := make([][]byte, 0, 3)
+
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidEnvelopedData},
+		{oidMessageDigest, digest[:]},
+		{oidRandomNonce, nonce},
+	} {
+		value, err := asn1.Marshal(a.value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err := asn1.Marshal(attribute{
+			Type:   a.oid,
+			Values: []asn1.RawValue{{FullBytes: value}},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		attributes = append(attributes, encoded)
+	}
+
+	// Signed attributes are DER encoded as a SET OF, sorted by encoding
+	sort.Slice(attributes, func(i, j int) bool {
+		return bytes.Compare(attributes[i], attributes[j]) < 0
+	})
+
+	signedAttrs := bytes.Join(attributes, nil)
+	hashed := sha256.Sum256(setOf(signedAttrs))
+
+	signature, err := kdhKey.Sign(rand.Reader, hashed[:], crypto.SHA256)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := asn1.Marshal(signedData{
+		Version:          signedDataVersion,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Identifier},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: oidEnvelopedData,
+			EContent:     content,
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: kdh.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            signerInfoVersion,
+			Sid:                getIssuerAndSerialNumber(kdh),
+			DigestAlgorithm:    sha256Identifier,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs},
+			SignatureAlgorithm: sha256WithRsa,
+			Signature:          signature,
+		}},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+	})
+}
+
+func parseAttributes(encoded []byte) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+
+	for rest := encoded; len(rest) > 0; {
+		var a attribute
+		var err error
+
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil || len(a.Values) != 1 {
+			return nil, ErrInvalidToken
+		}
+
+		result[a.Type.String()] = a.Values[0].FullBytes
+	}
+
+	return result, nil
+}
+
+func getOaepIdentifier() (pkix.AlgorithmIdentifier, error) {
+	mgf, err := asn1.Marshal(sha256Identifier)
+
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	parameters, err := asn1.Marshal(oaepParameters{
+		HashAlgorithm:    sha256Identifier,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidMgf1, Parameters: asn1.RawValue{FullBytes: mgf}},
+	})
+
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oidRsaesOaep,
+		Parameters: asn1.RawValue{FullBytes: parameters},
+	}, nil
+}
+
+func getIssuerAndSerialNumber(cert *x509.Certificate) issuerAndSerialNumber {
+	return issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	}
+}
+
+func (i issuerAndSerialNumber) matches(cert *x509.Certificate) bool {
+	return bytes.Equal(i.Issuer.FullBytes, cert.RawIssuer) && i.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// setOf returns the SET OF encoding of already encoded elements
+func setOf(elements []byte) []byte {
+	encoded, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: elements})
+
+	return encoded
+}
+
+func unmarshal(data []byte, value interface{}) error {
+	rest, err := asn1.Unmarshal(data, value)
+
+	if err == nil && len(rest) != 0 {
+		return ErrInvalidToken
+	}
+
+	return err
+}
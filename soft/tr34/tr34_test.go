@@ -0,0 +1,74 @@
+package tr34
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T, name string, serial int64) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	assert.Nil(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+
+	assert.Nil(t, err)
+
+	return cert, key
+}
+
+func TestToken(t *testing.T) {
+	kdh, kdhKey := newCertificate(t, "KDH", 1)
+	device, deviceKey := newCertificate(t, "Device", 2)
+	other, otherKey := newCertificate(t, "Other", 3)
+
+	ipek, _ := hex.DecodeString("6ac292faa1315b4d858ab3a3d7d5933a")
+	nonce, _ := hex.DecodeString("167eb0e72781e4940112233445566778")
+	header := "A0040B1TX00N0100KS18FFFF9876543210E00000"
+
+	token, err := GenerateToken(kdh, kdhKey, device, nonce, header, ipek)
+
+	assert.Nil(t, err)
+
+	parsed, err := ParseToken(token, kdh, deviceKey, device)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ipek, parsed.Key)
+	assert.Equal(t, nonce, parsed.Nonce)
+	assert.Equal(t, header, parsed.Header)
+
+	_, err = ParseToken(token, kdh, otherKey, other)
+
+	assert.Equal(t, ErrUnknownRecipient, err)
+
+	_, err = ParseToken(token, other, deviceKey, device)
+
+	assert.Equal(t, ErrUnknownKdh, err)
+
+	// A token signed by another host doesn't verify
+	forged, err := GenerateToken(kdh, otherKey, device, nonce, header, ipek)
+
+	assert.Nil(t, err)
+
+	_, err = ParseToken(forged, kdh, deviceKey, device)
+
+	assert.Equal(t, ErrInvalidSignature, err)
+}
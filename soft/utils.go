@@ -8,10 +8,13 @@ import (
 	"crypto/hmac"
 	"errors"
 	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft/card"
+	"github.com/pagarme/cryptokit/soft/pin"
+	"github.com/pagarme/cryptokit/soft/tdes"
 )
 
 func processAead(mech cryptokit.Gcm, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
-	impl, err := getImplementation(mech.Underlying, key)
+	impl, err := getImplementation(mech.Underlying, key.(*Key))
 
 	if err != nil {
 		return nil, err
@@ -31,7 +34,7 @@ func processAead(mech cryptokit.Gcm, key cryptokit.Key, in []byte, encrypt bool)
 }
 
 func processBlockCipher(mech cryptokit.BlockCipher, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
-	impl, err := getImplementation(mech.BlockCipherUnderlying(), key)
+	impl, err := getImplementation(mech.BlockCipherUnderlying(), key.(*Key))
 
 	if err != nil {
 		return nil, err
@@ -73,6 +76,193 @@ func processHmac(mech cryptokit.Hmac, key cryptokit.Key, in []byte, encrypt bool
 	return h.Sum(nil), nil
 }
 
+func processPinBlock(mech cryptokit.PinBlock, key cryptokit.Key, in []byte, encrypt bool) ([]byte, error) {
+	if !encrypt {
+		return nil, errors.New("PIN blocks can't be decrypted, use Translate instead")
+	}
+
+	impl, err := getImplementation(mech.Underlying, key.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pin.Encrypt(impl, mech.Format, string(in), mech.Pan)
+}
+
+func processCardVerificationValue(mech cryptokit.CardVerificationValue, key cryptokit.Key) ([]byte, error) {
+	serviceCode := mech.ServiceCode
+
+	switch mech.Type {
+	case cryptokit.Cvv2:
+		serviceCode = card.Cvv2ServiceCode
+	case cryptokit.Icvv:
+		serviceCode = card.IcvvServiceCode
+	}
+
+	cvv, err := card.Cvv(key.(*Key).data, mech.Pan, mech.Expiry, serviceCode, mech.Digits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(cvv), nil
+}
+
+func processDcvv(mech cryptokit.Dcvv, key cryptokit.Key) ([]byte, error) {
+	dcvv, err := card.Dcvv(key.(*Key).data, mech.Pan, mech.Expiry, mech.Atc, mech.Digits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(dcvv), nil
+}
+
+func processCvc3(mech cryptokit.Cvc3, key cryptokit.Key, in []byte) ([]byte, error) {
+	cvc3, err := card.Cvc3(key.(*Key).data, in, mech.UnpredictableNumber, mech.Atc, mech.Digits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(cvc3), nil
+}
+
+func processCavv(mech cryptokit.Cavv, key cryptokit.Key) ([]byte, error) {
+	cavv, err := card.Cavv(key.(*Key).data, mech.Pan, mech.Atn, mech.ResultsCode, mech.SecondFactorCode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(cavv), nil
+}
+
+func processAav(mech cryptokit.Aav, key cryptokit.Key, in []byte) ([]byte, error) {
+	underlying := mech.Underlying
+
+	if underlying == nil {
+		underlying = cryptokit.Sha1{}
+	}
+
+	impl, err := getHashImplementation(underlying)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return card.Aav(key.(*Key).data, impl.New, mech.Pan, in)
+}
+
+func processPvv(mech cryptokit.Pvv, key cryptokit.Key, in []byte) ([]byte, error) {
+	pinBlock, ok := mech.PinBlock.(cryptokit.PinBlock)
+
+	if !ok {
+		return nil, errors.New("PVVs require a PIN block")
+	}
+
+	p, err := decryptPinBlock(pinBlock, mech.PinKey, in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	impl, err := getKeyImplementation(key.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	pvv, err := pin.Pvv(impl, pinBlock.Pan, mech.Pvki, p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(pvv), nil
+}
+
+func processIbm3624(mech cryptokit.Ibm3624, key cryptokit.Key, in []byte) ([]byte, error) {
+	pinBlock, ok := mech.PinBlock.(cryptokit.PinBlock)
+
+	if !ok {
+		return nil, errors.New("IBM 3624 offsets require a PIN block")
+	}
+
+	p, err := decryptPinBlock(pinBlock, mech.PinKey, in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	impl, err := getKeyImplementation(key.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	length := mech.CheckLength
+
+	if length == 0 {
+		length = 4
+	}
+
+	natural, err := pin.Ibm3624NaturalPin(impl, mech.ValidationData, mech.DecimalizationTable, length)
+
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := pin.Ibm3624Offset(natural, p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(offset), nil
+}
+
+func translatePinBlock(inMech cryptokit.PinBlock, inKey cryptokit.Key, in []byte, outMech cryptokit.PinBlock, outKey cryptokit.Key) ([]byte, error) {
+	if err := checkCapability(outMech, outKey, cryptokit.Encrypt); err != nil {
+		return nil, err
+	}
+
+	p, err := decryptPinBlock(inMech, inKey, in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outImpl, err := getImplementation(outMech.Underlying, outKey.(*Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pin.Encrypt(outImpl, outMech.Format, p, outMech.Pan)
+}
+
+// decryptPinBlock recovers the PIN of a PIN block, which never leaves the
+// provider
+func decryptPinBlock(mech cryptokit.PinBlock, key cryptokit.Key, in []byte) (string, error) {
+	if key == nil {
+		return "", errors.New("A PIN block key is required")
+	}
+
+	if err := checkCapability(mech, key, cryptokit.Decrypt); err != nil {
+		return "", err
+	}
+
+	impl, err := getImplementation(mech.Underlying, key.(*Key))
+
+	if err != nil {
+		return "", err
+	}
+
+	return pin.Decrypt(impl, mech.Format, in, mech.Pan)
+}
+
 func getHashImplementation(mech cryptokit.Mechanism) (crypto.Hash, error) {
 	switch mech.(type) {
 	case cryptokit.Sha1:
@@ -86,21 +276,91 @@ func getHashImplementation(mech cryptokit.Mechanism) (crypto.Hash, error) {
 	return 0, errors.New("Unknown mechanism")
 }
 
-func getImplementation(mech cryptokit.Mechanism, key cryptokit.Key) (cipher.Block, error) {
-	skey := key.(*Key)
-
-	switch mech.(type) {
+func getImplementation(mech cryptokit.Mechanism, key *Key) (cipher.Block, error) {
+	switch v := mech.(type) {
 	case cryptokit.Aes:
-		return aes.NewCipher(skey.data)
+		return aes.NewCipher(key.data)
 	case cryptokit.Des:
-		return des.NewCipher(skey.data)
+		return des.NewCipher(key.data)
 	case cryptokit.Tdes:
-		return des.NewTripleDESCipher(skey.data)
+		return tdes.NewCipher(key.data)
+	case cryptokit.Dukpt:
+		underlying := v.Underlying
+
+		if underlying == nil {
+			underlying = cryptokit.Tdes{}
+
+			if v.Algorithm == cryptokit.DukptAes {
+				underlying = cryptokit.Aes{}
+			}
+		}
+
+		var typ cryptokit.KeyType = cryptokit.TdesKey
+		length := uint(16)
+
+		if _, ok := underlying.(cryptokit.Aes); ok {
+			typ, length = cryptokit.AesKey, uint(len(key.data))
+		}
+
+		derived, err := deriveDukptKey(v, key, typ, length)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The working key only lives for this operation
+		return getImplementation(underlying, &Key{
+			typ:    typ,
+			length: length,
+			data:   derived,
+		})
 	}
 
 	return nil, errors.New("Unknown mechanism")
 }
 
+// getKeyImplementation returns the block cipher matching the key's type
+func getKeyImplementation(key *Key) (cipher.Block, error) {
+	switch key.typ {
+	case cryptokit.AesKey:
+		return getImplementation(cryptokit.Aes{}, key)
+	case cryptokit.DesKey:
+		return getImplementation(cryptokit.Des{}, key)
+	case cryptokit.TdesKey:
+		return getImplementation(cryptokit.Tdes{}, key)
+	}
+
+	return nil, errors.New("Key isn't a block cipher key")
+}
+
+// derivesKey reports whether mech only needs its base key to allow
+// derivation: DUKPT data encryption and PIN blocks under DUKPT PIN keys,
+// whose clear PIN never leaves the provider. Modes wrapping Dukpt directly
+// use the capabilities of the base key.
+func derivesKey(mech cryptokit.Mechanism) bool {
+	switch v := mech.(type) {
+	case cryptokit.DukptData:
+		return true
+	case cryptokit.PinBlock:
+		_, ok := v.Underlying.(cryptokit.Dukpt)
+		return ok
+	}
+
+	return false
+}
+
+// usesDukptPinKey reports whether mech enciphers data other than PIN blocks
+// under a DUKPT PIN key, which would let PIN blocks be decrypted in clear
+func usesDukptPinKey(mech cryptokit.Mechanism) bool {
+	if _, ok := mech.(cryptokit.PinBlock); ok {
+		return false
+	}
+
+	d, ok := getDukptMechanism(mech)
+
+	return ok && d.Variant == cryptokit.DukptPinVariant
+}
+
 func getBlockImplementation(mech cryptokit.BlockCipher, impl cipher.Block, encrypt bool) (cipher.BlockMode, error) {
 	var c cipher.BlockMode
 
@@ -0,0 +1,84 @@
+package variant
+
+import (
+	"errors"
+
+	"github.com/pagarme/cryptokit/soft/tdes"
+)
+
+// Variants are XORed with the leftmost byte of a key encrypting key, the
+// same numbering as Thales LMK variants
+var Variants = []byte{0x00, 0xA6, 0x5A, 0x6A, 0xDE, 0x2B, 0x50, 0x74, 0x9C, 0xFA}
+
+// Key length variants of each part of double and triple length keys
+var (
+	DoubleLengthVariants = []byte{0xA6, 0x5A}
+	TripleLengthVariants = []byte{0x6A, 0xDE, 0x2B}
+)
+
+var (
+	ErrInvalidKek     = errors.New("Invalid key encrypting key")
+	ErrInvalidKey     = errors.New("Key length must be 8, 16 or 24 bytes")
+	ErrInvalidVariant = errors.New("Unknown key variant")
+)
+
+// Encrypt enciphers key under kek, a double or triple length TDES key, with
+// the key variant applied to the leftmost byte of its first part and, unless
+// x917 is set, the key length variant to the leftmost byte of its second
+// part
+func Encrypt(kek, key []byte, variant int, x917 bool) ([]byte, error) {
+	return process(kek, key, variant, x917, true)
+}
+
+func Decrypt(kek, key []byte, variant int, x917 bool) ([]byte, error) {
+	return process(kek, key, variant, x917, false)
+}
+
+func process(kek, key []byte, variant int, x917, encrypt bool) ([]byte, error) {
+	if len(kek) != 16 && len(kek) != 24 {
+		return nil, ErrInvalidKek
+	}
+
+	if variant < 0 || variant >= len(Variants) {
+		return nil, ErrInvalidVariant
+	}
+
+	var lengthVariants []byte
+
+	switch len(key) {
+	case 8:
+		lengthVariants = []byte{0x00}
+	case 16:
+		lengthVariants = DoubleLengthVariants
+	case 24:
+		lengthVariants = TripleLengthVariants
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	out := make([]byte, len(key))
+
+	for i, lengthVariant := range lengthVariants {
+		masked := append([]byte{}, kek...)
+
+		masked[0] ^= Variants[variant]
+
+		if !x917 {
+			masked[8] ^= lengthVariant
+		}
+
+		block, err := tdes.NewCipher(masked)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if encrypt {
+			block.Encrypt(out[i*8:], key[i*8:i*8+8])
+		} else {
+			block.Decrypt(out[i*8:], key[i*8:i*8+8])
+		}
+	}
+
+	return out, nil
+}
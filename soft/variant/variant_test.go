@@ -0,0 +1,42 @@
+package variant
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testKek, _ = hex.DecodeString("0123456789abcdeffedcba9876543210")
+var testKey, _ = hex.DecodeString("f039121bec83d26b169bdcd5b22aaf8f")
+
+func TestEncrypt(t *testing.T) {
+	encrypted, err := Encrypt(testKek, testKey, 1, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1296fc0e7922c8f55b3cd632eb09206c", hex.EncodeToString(encrypted))
+
+	decrypted, err := Decrypt(testKek, encrypted, 1, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, testKey, decrypted)
+
+	// Variant 0 with X9.17 is plain TDES in ECB mode
+	encrypted, err = Encrypt(testKek, testKey, 0, true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "c3c69451c0685db8e6b6dcd3f50e8769", hex.EncodeToString(encrypted))
+}
+
+func TestInvalidParameters(t *testing.T) {
+	_, err := Encrypt(testKek[:8], testKey, 0, false)
+
+	assert.Equal(t, ErrInvalidKek, err)
+
+	_, err = Encrypt(testKek, testKey[:12], 0, false)
+
+	assert.Equal(t, ErrInvalidKey, err)
+
+	_, err = Encrypt(testKek, testKey, 10, false)
+
+	assert.Equal(t, ErrInvalidVariant, err)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/hashicorp/vault/api"
 	"path"
+	"strconv"
 )
 
 type vaultDatabase struct {
@@ -109,6 +110,91 @@ func (b *vaultDatabase) Remove(id string) error {
 	return err
 }
 
+// Counters and routes live next to the keys so they aren't listed as keys.
+// Vault has no transactions, so concurrent updates of the same device may
+// race.
+func (b *vaultDatabase) FindCounter(device string) (uint64, bool, error) {
+	s, err := b.vault.Logical().Read(path.Join("secret", b.base+".counters", device))
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if s == nil {
+		return 0, false, nil
+	}
+
+	value, ok := s.Data["counter"].(string)
+
+	if !ok {
+		return 0, false, errors.New("error reading response from vault")
+	}
+
+	counter, err := strconv.ParseUint(value, 10, 64)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return counter, true, nil
+}
+
+func (b *vaultDatabase) AdvanceCounter(device string, counter uint64) (uint64, bool, error) {
+	previous, found, err := b.FindCounter(device)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if found && counter <= previous {
+		return previous, false, nil
+	}
+
+	_, err = b.vault.Logical().Write(path.Join("secret", b.base+".counters", device), map[string]interface{}{
+		"counter": strconv.FormatUint(counter, 10),
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return previous, true, nil
+}
+
+func (b *vaultDatabase) SaveRoute(ksi string, id string) error {
+	_, err := b.vault.Logical().Write(path.Join("secret", b.base+".routes", ksi), map[string]interface{}{
+		"id": id,
+	})
+
+	return err
+}
+
+func (b *vaultDatabase) FindRoute(ksi string) (string, bool, error) {
+	s, err := b.vault.Logical().Read(path.Join("secret", b.base+".routes", ksi))
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if s == nil {
+		return "", false, nil
+	}
+
+	id, ok := s.Data["id"].(string)
+
+	if !ok {
+		return "", false, errors.New("error reading response from vault")
+	}
+
+	return id, true, nil
+}
+
+func (b *vaultDatabase) RemoveRoute(ksi string) error {
+	_, err := b.vault.Logical().Delete(path.Join("secret", b.base+".routes", ksi))
+
+	return err
+}
+
 func (b *vaultDatabase) Close() error {
 	return nil
 }
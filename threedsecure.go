@@ -0,0 +1,30 @@
+package cryptokit
+
+// Cavv generates and verifies the CAVV output of Visa 3-D Secure
+// authentications, computed with the CVV with ATN algorithm, through
+// Session.Sign and Session.Verify with a double length CAVV key, the input
+// being ignored. ResultsCode is the 1 digit authentication results code and
+// SecondFactorCode the 2 digit second factor authentication code.
+type Cavv struct {
+	Pan              string `cmd:",primary"`
+	Atn              string
+	ResultsCode      string
+	SecondFactorCode string
+}
+
+func (m Cavv) Name() string {
+	return "cavv"
+}
+
+// Aav generates and verifies the MAC of Mastercard 3-D Secure AAVs with
+// the HMAC algorithm through Session.Sign and Session.Verify, the input
+// being the AAV fields preceding the MAC. Underlying is the hash, Sha1 by
+// default.
+type Aav struct {
+	Pan        string `cmd:",primary"`
+	Underlying Mechanism
+}
+
+func (m Aav) Name() string {
+	return "aav"
+}
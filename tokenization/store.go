@@ -0,0 +1,99 @@
+package tokenization
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// Store keeps the records of a vault: the encrypted PANs under their
+// tokens and, for indexed vaults, the tokens under the PAN fingerprints.
+// Records never hold PANs in clear, so the store needs no encryption of its
+// own, but it must be kept apart from any key store.
+type Store interface {
+	// SaveToken stores data under id unless it is already taken, reporting
+	// whether it was stored
+	SaveToken(id string, data []byte) (bool, error)
+	FindToken(id string) ([]byte, bool, error)
+	RemoveToken(id string) error
+}
+
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("tokens"))
+
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) SaveToken(id string, data []byte) (bool, error) {
+	var saved bool
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("tokens"))
+
+		if bkt.Get([]byte(id)) != nil {
+			return nil
+		}
+
+		saved = true
+
+		return bkt.Put([]byte(id), data)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return saved, nil
+}
+
+func (b *BoltStore) FindToken(id string) ([]byte, bool, error) {
+	var bytes []byte
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("tokens"))
+
+		// Values are only valid during the transaction
+		bytes = append([]byte{}, bkt.Get([]byte(id))...)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(bytes) == 0 {
+		return nil, false, nil
+	}
+
+	return bytes, true, nil
+}
+
+func (b *BoltStore) RemoveToken(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("tokens"))
+
+		return bkt.Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,326 @@
+package tokenization
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"github.com/pagarme/cryptokit"
+)
+
+type Format int
+
+const (
+	// Random digits replace the middle of the PAN, the token failing the
+	// Luhn check so it can't be mistaken for a card number
+	RandomToken Format = iota
+	// The middle of the PAN is enciphered with FF1, the BIN and last four
+	// digits being the tweak. FF1 needs at least 6 digits, so only PANs of
+	// 16 digits or more can be tokenized.
+	FpeToken
+)
+
+const (
+	binLength      = 6
+	lastFourLength = 4
+	minFpeLength   = 6
+	maxAttempts    = 32
+	tokenPrefix    = "token:"
+	indexPrefix    = "pan:"
+)
+
+var (
+	ErrInvalidPan     = errors.New("PAN must have between 13 and 19 digits")
+	ErrPanTooShort    = errors.New("FPE tokens require PANs of at least 16 digits")
+	ErrTokenNotFound  = errors.New("Token not found")
+	ErrTokenCollision = errors.New("Couldn't generate an unused token")
+)
+
+// Config selects the keys used by a vault. Key must be an AES key allowed
+// to encrypt, to tokenize, and to decrypt, to detokenize. FpeKey is the AES
+// key used by FpeToken vaults. IndexKey, an HMAC key, is optional: when set
+// PANs are fingerprinted so tokenizing a PAN again returns its token,
+// otherwise RandomToken vaults issue a new token every time.
+type Config struct {
+	Format   Format
+	Key      cryptokit.Key
+	FpeKey   cryptokit.Key
+	IndexKey cryptokit.Key
+}
+
+// Vault maps PANs to tokens preserving their BIN and last four digits. The
+// PANs are stored encrypted under the vault key, every cryptographic
+// operation going through the session.
+type Vault struct {
+	session cryptokit.Session
+	db      Store
+	config  Config
+}
+
+func New(session cryptokit.Session, db Store, config Config) (*Vault, error) {
+	if config.Key == nil {
+		return nil, errors.New("A vault key is required")
+	}
+
+	switch config.Format {
+	case RandomToken:
+	case FpeToken:
+		if config.FpeKey == nil {
+			return nil, errors.New("FPE tokens require an FPE key")
+		}
+	default:
+		return nil, errors.New("Unknown token format")
+	}
+
+	return &Vault{
+		session: session,
+		db:      db,
+		config:  config,
+	}, nil
+}
+
+// Tokenize returns the token of the PAN, storing the mapping
+func (v *Vault) Tokenize(pan string) (string, error) {
+	if !isPan(pan) {
+		return "", ErrInvalidPan
+	}
+
+	if v.config.Format == FpeToken && len(pan)-binLength-lastFourLength < minFpeLength {
+		return "", ErrPanTooShort
+	}
+
+	fingerprint, err := v.fingerprint(pan)
+
+	if err != nil {
+		return "", err
+	}
+
+	if fingerprint != "" {
+		token, found, err := v.db.FindToken(fingerprint)
+
+		if err != nil {
+			return "", err
+		}
+
+		if found {
+			return string(token), nil
+		}
+	}
+
+	token, err := v.store(pan)
+
+	if err != nil {
+		return "", err
+	}
+
+	if fingerprint == "" {
+		return token, nil
+	}
+
+	saved, err := v.db.SaveToken(fingerprint, []byte(token))
+
+	if err != nil {
+		return "", err
+	}
+
+	if saved {
+		return token, nil
+	}
+
+	// The PAN was tokenized concurrently, the other token wins
+	existing, _, err := v.db.FindToken(fingerprint)
+
+	if err != nil {
+		return "", err
+	}
+
+	if string(existing) != token {
+		if err := v.db.RemoveToken(tokenPrefix + token); err != nil {
+			return "", err
+		}
+	}
+
+	return string(existing), nil
+}
+
+// Detokenize returns the PAN a token maps to, which requires the vault key
+// to be allowed to decrypt
+func (v *Vault) Detokenize(token string) (string, error) {
+	record, found, err := v.db.FindToken(tokenPrefix + token)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "", ErrTokenNotFound
+	}
+
+	if len(record) < 12 {
+		return "", errors.New("Invalid token record")
+	}
+
+	pan, err := v.session.Decrypt(cryptokit.Gcm{
+		Underlying:     cryptokit.Aes{},
+		Nonce:          record[:12],
+		AdditionalData: []byte(token),
+	}, v.config.Key, record[12:])
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(pan), nil
+}
+
+// store generates an unused token for the PAN and saves the encrypted PAN
+// under it
+func (v *Vault) store(pan string) (string, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		token, err := v.generate(pan)
+
+		if err != nil {
+			return "", err
+		}
+
+		record, err := v.encrypt(token, pan)
+
+		if err != nil {
+			return "", err
+		}
+
+		saved, err := v.db.SaveToken(tokenPrefix+token, record)
+
+		if err != nil {
+			return "", err
+		}
+
+		// FPE tokens are unique to their PAN, so a taken one is already
+		// the PAN's
+		if saved || v.config.Format == FpeToken {
+			return token, nil
+		}
+	}
+
+	return "", ErrTokenCollision
+}
+
+func (v *Vault) generate(pan string) (string, error) {
+	bin, middle, lastFour := pan[:binLength], pan[binLength:len(pan)-lastFourLength], pan[len(pan)-lastFourLength:]
+
+	if v.config.Format == FpeToken {
+		out, err := v.session.Encrypt(cryptokit.Ff1{
+			Radix: 10,
+			Tweak: []byte(bin + lastFour),
+		}, v.config.FpeKey, []byte(middle))
+
+		if err != nil {
+			return "", err
+		}
+
+		return bin + string(out) + lastFour, nil
+	}
+
+	for {
+		digits, err := randomDigits(len(middle))
+
+		if err != nil {
+			return "", err
+		}
+
+		token := bin + digits + lastFour
+
+		if !luhn(token) {
+			return token, nil
+		}
+	}
+}
+
+func (v *Vault) encrypt(token, pan string) ([]byte, error) {
+	nonce := make([]byte, 12)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := v.session.Encrypt(cryptokit.Gcm{
+		Underlying:     cryptokit.Aes{},
+		Nonce:          nonce,
+		AdditionalData: []byte(token),
+	}, v.config.Key, []byte(pan))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(nonce, ciphertext...), nil
+}
+
+// fingerprint returns the id of the PAN's index record, if the vault keeps
+// an index
+func (v *Vault) fingerprint(pan string) (string, error) {
+	if v.config.IndexKey == nil {
+		return "", nil
+	}
+
+	mac, err := v.session.Sign(cryptokit.Hmac{
+		Underlying: cryptokit.Sha256{},
+	}, v.config.IndexKey, []byte(pan))
+
+	if err != nil {
+		return "", err
+	}
+
+	return indexPrefix + hex.EncodeToString(mac), nil
+}
+
+func randomDigits(n int) (string, error) {
+	digits := make([]byte, n)
+
+	for i := range digits {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+
+		if err != nil {
+			return "", err
+		}
+
+		digits[i] = '0' + byte(d.Int64())
+	}
+
+	return string(digits), nil
+}
+
+func isPan(pan string) bool {
+	if len(pan) < 13 || len(pan) > 19 {
+		return false
+	}
+
+	for _, c := range pan {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func luhn(number string) bool {
+	sum := 0
+
+	for i := 0; i < len(number); i++ {
+		d := int(number[len(number)-1-i] - '0')
+
+		if i%2 == 1 {
+			d *= 2
+
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+	}
+
+	return sum%10 == 0
+}
@@ -0,0 +1,149 @@
+package tokenization
+
+import (
+	"github.com/pagarme/cryptokit"
+	"github.com/pagarme/cryptokit/soft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+var testMasterKey = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+
+const testPan = "4111111111111111"
+
+func generateKey(t *testing.T, s cryptokit.Session, typ cryptokit.KeyType, capabilities cryptokit.KeyCapability) cryptokit.Key {
+	key, err := s.Generate(cryptokit.Random{}, cryptokit.KeyAttributes{
+		Type:         typ,
+		Length:       32,
+		Capabilities: capabilities,
+	})
+
+	assert.Nil(t, err)
+
+	return key
+}
+
+func TestVault(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := soft.New("testdb.db", testMasterKey)
+	assert.Nil(t, err)
+
+	s, err := p.OpenSession()
+	assert.Nil(t, err)
+
+	store, err := NewBoltStore("testtokens.db")
+	assert.Nil(t, err)
+
+	defer os.Remove("testtokens.db")
+	defer store.Close()
+	defer p.Close()
+	defer s.Close()
+
+	key := generateKey(t, s, cryptokit.AesKey, cryptokit.Encrypt|cryptokit.Decrypt)
+	fpeKey := generateKey(t, s, cryptokit.AesKey, cryptokit.Encrypt)
+	indexKey := generateKey(t, s, cryptokit.RawKey, cryptokit.Sign)
+
+	// Random tokens
+	v, err := New(s, store, Config{Key: key})
+	assert.Nil(t, err)
+
+	token, err := v.Tokenize(testPan)
+	assert.Nil(t, err)
+	assert.Len(t, token, len(testPan))
+	assert.Equal(t, testPan[:6], token[:6])
+	assert.Equal(t, testPan[12:], token[12:])
+	assert.False(t, luhn(token))
+
+	pan, err := v.Detokenize(token)
+	assert.Nil(t, err)
+	assert.Equal(t, testPan, pan)
+
+	other, err := v.Tokenize(testPan)
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, other)
+
+	// Indexed random tokens
+	v, err = New(s, store, Config{Key: key, IndexKey: indexKey})
+	assert.Nil(t, err)
+
+	token, err = v.Tokenize(testPan)
+	assert.Nil(t, err)
+
+	other, err = v.Tokenize(testPan)
+	assert.Nil(t, err)
+	assert.Equal(t, token, other)
+
+	// FPE tokens
+	v, err = New(s, store, Config{Format: FpeToken, Key: key, FpeKey: fpeKey})
+	assert.Nil(t, err)
+
+	token, err = v.Tokenize(testPan)
+	assert.Nil(t, err)
+	assert.Equal(t, testPan[:6], token[:6])
+	assert.Equal(t, testPan[12:], token[12:])
+	assert.NotEqual(t, testPan, token)
+
+	other, err = v.Tokenize(testPan)
+	assert.Nil(t, err)
+	assert.Equal(t, token, other)
+
+	pan, err = v.Detokenize(token)
+	assert.Nil(t, err)
+	assert.Equal(t, testPan, pan)
+
+	// FF1 can't encipher the 5 middle digits of 15 digit PANs
+	_, err = v.Tokenize("378282246310005")
+	assert.Equal(t, ErrPanTooShort, err)
+
+	// Detokenizing requires the vault key to decrypt
+	encryptOnly := generateKey(t, s, cryptokit.AesKey, cryptokit.Encrypt)
+
+	v, err = New(s, store, Config{Key: encryptOnly})
+	assert.Nil(t, err)
+
+	token, err = v.Tokenize(testPan)
+	assert.Nil(t, err)
+
+	_, err = v.Detokenize(token)
+	assert.NotNil(t, err)
+}
+
+func TestVaultErrors(t *testing.T) {
+	defer os.Remove("testdb.db")
+
+	p, err := soft.New("testdb.db", testMasterKey)
+	assert.Nil(t, err)
+
+	s, err := p.OpenSession()
+	assert.Nil(t, err)
+
+	store, err := NewBoltStore("testtokens.db")
+	assert.Nil(t, err)
+
+	defer os.Remove("testtokens.db")
+	defer store.Close()
+	defer p.Close()
+	defer s.Close()
+
+	key := generateKey(t, s, cryptokit.AesKey, cryptokit.Encrypt|cryptokit.Decrypt)
+
+	_, err = New(s, store, Config{})
+	assert.NotNil(t, err)
+
+	_, err = New(s, store, Config{Format: FpeToken, Key: key})
+	assert.NotNil(t, err)
+
+	v, err := New(s, store, Config{Key: key})
+	assert.Nil(t, err)
+
+	_, err = v.Tokenize("411111111111")
+	assert.Equal(t, ErrInvalidPan, err)
+
+	_, err = v.Tokenize("41111111111x1111")
+	assert.Equal(t, ErrInvalidPan, err)
+
+	_, err = v.Detokenize("4111110000001111")
+	assert.Equal(t, ErrTokenNotFound, err)
+}
@@ -0,0 +1,19 @@
+package cryptokit
+
+// Tr31 wraps and unwraps keys as ANSI X9.143 (TR-31) key blocks, carrying
+// the key usage, algorithm, mode of use and exportability along with the
+// key. Version defaults to "D" for AES key block protection keys and "B"
+// for TDES ones. KeyUsage, such as "P0" or "K0", defaults to the key's
+// Tr31KeyUsageMetadata and, when unwrapping, restricts the accepted blocks.
+// IncludeKcv adds a KC optional block, checked on import.
+type Tr31 struct {
+	KeyUsage      string `cmd:",primary"`
+	Version       string
+	KeyVersion    string
+	NonExportable bool
+	IncludeKcv    bool
+}
+
+func (m Tr31) Name() string {
+	return "tr31"
+}
@@ -0,0 +1,20 @@
+package cryptokit
+
+// Tr34 wraps keys, typically DUKPT initial keys, as ANSI X9.24-2 (TR-34) two
+// pass key tokens for remote key loading. The wrapping key is the key
+// distribution host's RSA private key and KdhCertificate its DER encoded
+// certificate. The key is enciphered for the device holding
+// DeviceCertificate, which must be issued by CaCertificate, Nonce being the
+// random number of its key receive token. KeyUsage defaults to the key's
+// Tr31KeyUsageMetadata or B1.
+type Tr34 struct {
+	DeviceCertificate []byte `cmd:",primary"`
+	CaCertificate     []byte
+	KdhCertificate    []byte
+	Nonce             []byte
+	KeyUsage          string
+}
+
+func (m Tr34) Name() string {
+	return "tr34"
+}